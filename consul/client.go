@@ -1,26 +1,84 @@
 package consul
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
+	"net/http"
 	"os"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/hashicorp/consul/api"
+	"github.com/rqlite/rqlite-disco-clients/disco"
 	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/internal/config"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/internal/opstats"
+	"github.com/rqlite/rqlite-disco-clients/internal/tracing"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// watchRetryInterval is how long a watch waits before retrying after
+	// an error.
+	watchRetryInterval = time.Second
+
+	// leaseLockDelay is the lock-delay of sessions to which leader records
+	// are bound. It is kept minimal so a new leader can be recorded as soon
+	// as the previous record expires.
+	leaseLockDelay = time.Millisecond
 )
 
 // Client represents a Consul client.
 type Client struct {
 	client    *api.KV
+	session   *api.Session
+	address   string
 	key       string
 	leaderKey string
+	nodesKey  string
+
+	retryPolicy *retry.Policy
+	timeout     time.Duration
+	clock       disco.Clock
+	logger      *slog.Logger
+	metrics     *metrics.Registry
+	tracer      trace.Tracer
+	stats       opstats.Recorder
+	lastLeader  disco.Leader
+
+	mu          sync.Mutex
+	leaseTTL    time.Duration
+	sessionID   string
+	leaseLostCh chan error
+	leaseCtx    context.Context
+	leaseCancel context.CancelFunc
+
+	electionTTL       time.Duration
+	electionLockDelay time.Duration
+	election          *election
 }
 
-// NewConfigFromFile parses the file at path and returns a Config.
-func NewConfigFromFile(path string) (*Config, error) {
+var (
+	_ disco.LeaderStore   = (*Client)(nil)
+	_ disco.LeaderWatcher = (*Client)(nil)
+	_ disco.NodeRegistry  = (*Client)(nil)
+	_ disco.Elector       = (*Client)(nil)
+)
+
+// NewConfigFromFile parses the file at path and returns a Config. The file
+// may be written in JSON, YAML or TOML, as given by its extension, or
+// detected from its content if the extension is not known. Environment
+// variables are expanded as configured by opts, such as expand.Strict, the
+// Config is checked with Validate, and the fields read from files are set.
+func NewConfigFromFile(path string, opts ...expand.Option) (*Config, error) {
 	cfgFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -33,7 +91,13 @@ func NewConfigFromFile(path string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal(path, b, &cfg, opts...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.readFiles(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -41,7 +105,11 @@ func NewConfigFromFile(path string) (*Config, error) {
 
 // NewConfigFromReader parses the data returned by the reader and
 // returns a Config. A nil reader results in nil config.
-func NewConfigFromReader(r io.Reader) (*Config, error) {
+// The data may be written in JSON, YAML or TOML, which is detected from its
+// content. Environment variables are expanded as configured by opts, such
+// as expand.Strict, the Config is checked with Validate, and the fields read
+// from files are set.
+func NewConfigFromReader(r io.Reader, opts ...expand.Option) (*Config, error) {
 	if r == nil {
 		return nil, nil
 	}
@@ -50,11 +118,14 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 		return nil, err
 	}
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal("", b, &cfg, opts...); err != nil {
 		return nil, err
 	}
-	if strings.HasPrefix(cfg.Address, "http") {
-		return nil, fmt.Errorf("address should not contain HTTP or HTTPS")
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.readFiles(); err != nil {
+		return nil, err
 	}
 	return &cfg, nil
 }
@@ -62,21 +133,107 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 // New returns an instantiated Consul client. If the cfg is nil, the default
 // config is used.
 func New(key string, cfg *Config) (*Client, error) {
-	c, err := api.NewClient(consulConfigFromClientConfig(cfg))
+	return NewClient(key, cfg)
+}
+
+// NewClient returns an instantiated Consul client, configured by cfg and
+// opts. If the cfg is nil, the default config is used.
+func NewClient(key string, cfg *Config, opts ...Option) (*Client, error) {
+	o := newOptions(cfg, opts)
+	apiConfig := consulConfigFromClientConfig(cfg)
+	if o.httpClient != nil {
+		apiConfig.HttpClient = o.httpClient
+	}
+	c, err := api.NewClient(apiConfig)
 	if err != nil {
 		return nil, err
 	}
+	address := apiConfig.Address
+	if address == "" {
+		address = api.DefaultConfig().Address
+	}
 	return &Client{
 		client:    c.KV(),
+		session:   c.Session(),
+		address:   address,
 		key:       key,
 		leaderKey: fmt.Sprintf("%s/leader", key),
+		nodesKey:  fmt.Sprintf("%s/nodes/", key),
+
+		retryPolicy: o.retry,
+		timeout:     o.timeout,
+		clock:       o.clock,
+		logger:      o.logger.With(logging.KeyBackend, "consul-kv", logging.KeyKey, key),
+		metrics:     o.metrics,
+		tracer:      tracing.Tracer(o.tracerProvider),
+		stats:       opstats.Recorder{Now: o.clock.Now},
+
+		electionTTL:       defaultElectionTTL,
+		electionLockDelay: defaultElectionLockDelay,
 	}, nil
 }
 
+func init() {
+	disco.Register("consul-kv", newFromConfig)
+}
+
+// newFromConfig is the disco.Factory of Consul clients.
+func newFromConfig(r io.Reader, o *disco.Options) (disco.Client, error) {
+	var expandOpts []expand.Option
+	if o.StrictEnv {
+		expandOpts = append(expandOpts, expand.Strict())
+	}
+	cfg, err := NewConfigFromReader(r, expandOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(o.Key, cfg, WithLogger(o.Logger), WithTimeout(o.Timeout),
+		WithClock(o.Clock), WithTracerProvider(o.TracerProvider))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
 // GetLeader returns the leader as recorded in Consul. If a leader exists, ok will
 // be set to true, false otherwise.
 func (c *Client) GetLeader() (id string, apiAddr string, addr string, ok bool, e error) {
-	pair, _, err := c.client.Get(c.leaderKey, nil)
+	return c.GetLeaderContext(context.Background())
+}
+
+// GetLeaderContext is like GetLeader, but the request to Consul is bound
+// to ctx.
+func (c *Client) GetLeaderContext(ctx context.Context) (id string, apiAddr string, addr string, ok bool, e error) {
+	rec, ok, err := c.GetLeaderRecordContext(ctx)
+	if err != nil || !ok {
+		return "", "", "", false, err
+	}
+	return rec.ID, rec.APIAddr, rec.Addr, true, nil
+}
+
+// GetLeaderRecord returns the leader as recorded in Consul, along with the
+// CreateIndex and ModifyIndex of the record. The ModifyIndex is also used
+// as the Term. If a leader exists, ok will be set to true, false otherwise.
+func (c *Client) GetLeaderRecord() (rec disco.LeaderRecord, ok bool, e error) {
+	return c.GetLeaderRecordContext(context.Background())
+}
+
+// GetLeaderRecordContext is like GetLeaderRecord, but the request to Consul
+// is bound to ctx.
+func (c *Client) GetLeaderRecordContext(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
+	e = c.do(ctx, "get_leader", func(ctx context.Context) (err error) {
+		rec, ok, err = c.getLeaderRecord(ctx)
+		if err == nil {
+			tracing.SetFound(ctx, ok)
+		}
+		return err
+	})
+	return
+}
+
+// getLeaderRecord makes a single attempt at GetLeaderRecordContext.
+func (c *Client) getLeaderRecord(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
+	pair, _, err := c.client.Get(c.leaderKey, (&api.QueryOptions{}).WithContext(ctx))
 	if err != nil {
 		e = err
 		return
@@ -87,27 +244,63 @@ func (c *Client) GetLeader() (id string, apiAddr string, addr string, ok bool, e
 	}
 
 	n := node{}
-	if err := json.Unmarshal(pair.Value, &n); err != nil {
+	if err := decodeNode(pair.Value, &n); err != nil {
 		e = err
 		return
 	}
-	return n.ID, n.APIAddr, n.Addr, true, nil
+	c.sawLeader(n.leader())
+	return disco.LeaderRecord{
+		Leader:         n.leader(),
+		CreateRevision: pair.CreateIndex,
+		ModRevision:    pair.ModifyIndex,
+		Term:           pair.ModifyIndex,
+		UpdatedAt:      n.UpdatedAt,
+	}, true, nil
 }
 
 // InitializeLeader sets the leader to the given details, but only if no leader
 // has already been set. This operation is a check-and-set type operation. If
 // initialization succeeds, ok is set to true.
 func (c *Client) InitializeLeader(id, apiAddr, addr string) (bool, error) {
-	b, err := json.Marshal(node{
-		ID:      id,
-		APIAddr: apiAddr,
-		Addr:    addr,
+	return c.InitializeLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// InitializeLeaderContext is like InitializeLeader, but the request to
+// Consul is bound to ctx.
+func (c *Client) InitializeLeaderContext(ctx context.Context, id, apiAddr, addr string) (ok bool, e error) {
+	n := c.newNode(id, apiAddr, addr)
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.initializeLeader(ctx, n)
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n)
+	})
+	e = c.do(ctx, "initialize_leader", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
+		return err
 	})
+	c.recordCAS("initialize_leader", id, ok, e)
+	return
+}
+
+// initializeLeader makes a single attempt at InitializeLeaderContext.
+func (c *Client) initializeLeader(ctx context.Context, n node) (bool, error) {
+	b, err := json.Marshal(n)
 	if err != nil {
 		return false, err
 	}
+	sessionID, err := c.leaseSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	if sessionID != "" {
+		return c.lockKey(ctx, c.leaderKey, sessionID, b, &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: c.leaderKey})
+	}
+
 	p := &api.KVPair{Key: c.leaderKey, Value: b}
-	ok, _, err := c.client.CAS(p, nil)
+	ok, _, err := c.client.CAS(p, (&api.WriteOptions{}).WithContext(ctx))
 	if err != nil {
 		return false, err
 	}
@@ -116,36 +309,690 @@ func (c *Client) InitializeLeader(id, apiAddr, addr string) (bool, error) {
 
 // SetLeader unconditionally sets the leader to the given details.
 func (c *Client) SetLeader(id, apiAddr, addr string) error {
+	return c.SetLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// SetLeaderContext is like SetLeader, but the request to Consul is bound
+// to ctx.
+func (c *Client) SetLeaderContext(ctx context.Context, id, apiAddr, addr string) error {
+	err := c.do(ctx, "set_leader", func(ctx context.Context) error {
+		return c.setLeader(ctx, id, apiAddr, addr)
+	})
+	if err == nil {
+		c.logger.Info("leader set", logging.KeyLeaderID, id)
+	}
+	return err
+}
+
+// setLeader makes a single attempt at SetLeaderContext.
+func (c *Client) setLeader(ctx context.Context, id, apiAddr, addr string) error {
 	b, err := json.Marshal(node{
-		ID:      id,
-		APIAddr: apiAddr,
-		Addr:    addr,
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
 	})
 	if err != nil {
 		return err
 	}
+	sessionID, err := c.leaseSession(ctx)
+	if err != nil {
+		return err
+	}
+	if sessionID != "" {
+		_, err := c.lockKey(ctx, c.leaderKey, sessionID, b, nil)
+		return err
+	}
+
 	p := &api.KVPair{Key: c.leaderKey, Value: b}
-	_, err = c.client.Put(p, nil)
+	_, err = c.client.Put(p, (&api.WriteOptions{}).WithContext(ctx))
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// UpdateLeaderIf sets the leader to leader, but only if the leader recorded
+// in Consul still matches expected. A zero expected matches only when no
+// leader is recorded. The update is a check-and-set on the ModifyIndex of
+// the record which was compared, so ok is false if the record did not
+// match, or if it changed between the comparison and the update.
+func (c *Client) UpdateLeaderIf(expected, leader disco.Leader) (bool, error) {
+	return c.UpdateLeaderIfContext(context.Background(), expected, leader)
+}
+
+// UpdateLeaderIfContext is like UpdateLeaderIf, but the requests to Consul
+// are bound to ctx.
+func (c *Client) UpdateLeaderIfContext(ctx context.Context, expected, leader disco.Leader) (ok bool, e error) {
+	n := c.newNode(leader.ID, leader.APIAddr, leader.Addr)
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.updateLeaderIf(ctx, expected, n)
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n)
+	})
+	e = c.do(ctx, "update_leader_if", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
+		return err
+	})
+	c.recordCAS("update_leader_if", leader.ID, ok, e)
+	return
+}
+
+// updateLeaderIf makes a single attempt at UpdateLeaderIfContext.
+func (c *Client) updateLeaderIf(ctx context.Context, expected disco.Leader, n node) (bool, error) {
+	pair, _, err := c.client.Get(c.leaderKey, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	var index uint64
+	if pair == nil {
+		if expected != (disco.Leader{}) {
+			return false, nil
+		}
+	} else {
+		cur := node{}
+		if err := decodeNode(pair.Value, &cur); err != nil {
+			return false, err
+		}
+		if cur.leader() != expected {
+			return false, nil
+		}
+		index = pair.ModifyIndex
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		return false, err
+	}
+
+	sessionID, err := c.leaseSession(ctx)
+	if err != nil {
+		return false, err
+	}
+	if sessionID != "" {
+		check := &api.KVTxnOp{Verb: api.KVCheckIndex, Key: c.leaderKey, Index: index}
+		if index == 0 {
+			check = &api.KVTxnOp{Verb: api.KVCheckNotExists, Key: c.leaderKey}
+		}
+		return c.lockKey(ctx, c.leaderKey, sessionID, b, check)
+	}
+
+	p := &api.KVPair{Key: c.leaderKey, Value: b, ModifyIndex: index}
+	ok, _, err := c.client.CAS(p, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// ResignLeader deletes the leader record, but only if it is still owned by
+// the node with the given id. The delete is a check-and-set on the
+// ModifyIndex of the record which was checked, so ok is false if the record
+// is owned by another node, does not exist, or changed in the meantime.
+func (c *Client) ResignLeader(id string) (bool, error) {
+	return c.ResignLeaderContext(context.Background(), id)
+}
+
+// ResignLeaderContext is like ResignLeader, but the requests to Consul are
+// bound to ctx.
+func (c *Client) ResignLeaderContext(ctx context.Context, id string) (ok bool, e error) {
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.resignLeader(ctx, id)
+	}, c.noLeaderRecord)
+	e = c.do(ctx, "resign_leader", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
+		return err
+	})
+	c.recordCAS("resign_leader", id, ok, e)
+	return
+}
+
+// resignLeader makes a single attempt at ResignLeaderContext.
+func (c *Client) resignLeader(ctx context.Context, id string) (bool, error) {
+	pair, _, err := c.client.Get(c.leaderKey, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if pair == nil {
+		return false, nil
+	}
+	n := node{}
+	if err := decodeNode(pair.Value, &n); err != nil {
+		return false, err
+	}
+	if n.ID != id {
+		return false, nil
+	}
+
+	ok, _, err := c.client.DeleteCAS(pair, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// DeleteLeader unconditionally deletes the leader record. It is intended
+// for administrative recovery.
+func (c *Client) DeleteLeader() error {
+	return c.DeleteLeaderContext(context.Background())
+}
+
+// DeleteLeaderContext is like DeleteLeader, but the request to Consul is
+// bound to ctx.
+func (c *Client) DeleteLeaderContext(ctx context.Context) error {
+	return c.do(ctx, "delete_leader", func(ctx context.Context) error {
+		return c.deleteLeader(ctx)
+	})
+}
+
+// deleteLeader makes a single attempt at DeleteLeaderContext.
+func (c *Client) deleteLeader(ctx context.Context) error {
+	_, err := c.client.Delete(c.leaderKey, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// RegisterNode records the node with the given details under the nodes
+// prefix of the key, replacing any existing record for id. If leases are
+// enabled, the record is bound to the session, and so is removed if this
+// client stops renewing it.
+func (c *Client) RegisterNode(id, apiAddr, addr string) error {
+	return c.RegisterNodeContext(context.Background(), id, apiAddr, addr)
+}
+
+// RegisterNodeContext is like RegisterNode, but the requests to Consul are
+// bound to ctx.
+func (c *Client) RegisterNodeContext(ctx context.Context, id, apiAddr, addr string) error {
+	return c.do(ctx, "register_node", func(ctx context.Context) error {
+		return c.registerNode(ctx, id, apiAddr, addr)
+	})
+}
+
+// registerNode makes a single attempt at RegisterNodeContext.
+func (c *Client) registerNode(ctx context.Context, id, apiAddr, addr string) error {
+	b, err := json.Marshal(node{
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	sessionID, err := c.leaseSession(ctx)
+	if err != nil {
+		return err
+	}
+	if sessionID != "" {
+		_, err := c.lockKey(ctx, c.nodesKey+id, sessionID, b, nil)
+		return err
+	}
+
+	p := &api.KVPair{Key: c.nodesKey + id, Value: b}
+	_, err = c.client.Put(p, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// DeregisterNode removes the record of the node with the given id. It is
+// not an error if no such record exists.
+func (c *Client) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext is like DeregisterNode, but the request to Consul
+// is bound to ctx.
+func (c *Client) DeregisterNodeContext(ctx context.Context, id string) error {
+	return c.do(ctx, "deregister_node", func(ctx context.Context) error {
+		return c.deregisterNode(ctx, id)
+	})
+}
+
+// deregisterNode makes a single attempt at DeregisterNodeContext.
+func (c *Client) deregisterNode(ctx context.Context, id string) error {
+	_, err := c.client.Delete(c.nodesKey+id, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// ListNodes returns every registered node, sorted by ID.
+func (c *Client) ListNodes() ([]disco.Node, error) {
+	return c.ListNodesContext(context.Background())
+}
+
+// ListNodesContext is like ListNodes, but the request to Consul is bound
+// to ctx.
+func (c *Client) ListNodesContext(ctx context.Context) (nodes []disco.Node, e error) {
+	e = c.do(ctx, "list_nodes", func(ctx context.Context) (err error) {
+		nodes, err = c.listNodes(ctx)
+		tracing.SetRecordCount(ctx, len(nodes))
+		return err
+	})
+	return
+}
+
+// listNodes makes a single attempt at ListNodesContext.
+func (c *Client) listNodes(ctx context.Context) ([]disco.Node, error) {
+	pairs, _, err := c.client.List(c.nodesKey, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	// Consul returns keys in lexical order, which is also the order of IDs.
+	nodes := make([]disco.Node, 0, len(pairs))
+	for _, pair := range pairs {
+		n := node{}
+		if err := decodeNode(pair.Value, &n); err != nil {
+			return nil, fmt.Errorf("%s: %w", pair.Key, err)
+		}
+		nodes = append(nodes, disco.Node{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr})
+	}
+	return nodes, nil
+}
+
+// EnableLeaderLease binds leader records written by subsequent calls to
+// InitializeLeader and SetLeader to a Consul session with the given TTL and
+// the delete behavior, so that the record is removed if this client stops
+// renewing the session, for example because the process has crashed. Node
+// records written by RegisterNode are bound to the same session. The
+// session is created on the next write, and renewed in the background until
+// the client is closed. Consul requires the TTL to be between 10s and 24h.
+//
+// The returned channel receives an error wrapping disco.ErrLeaseLost each
+// time the session is invalidated or can no longer be renewed, after which
+// the next write is bound to a new session. The channel is closed when the
+// client is closed.
+//
+// EnableLeaderLease only affects subsequent writes. In particular, calling
+// it with a TTL of 0 stops binding new records to a session, but records
+// already written remain bound to the current session, which is renewed
+// until the client is closed.
+func (c *Client) EnableLeaderLease(ttl time.Duration) <-chan error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leaseTTL = ttl
+	if c.leaseLostCh == nil {
+		c.leaseLostCh = make(chan error, 1)
+		c.leaseCtx, c.leaseCancel = context.WithCancel(context.Background())
+	}
+	return c.leaseLostCh
+}
+
+// leaseSession returns the ID of the session to which records should be
+// bound, creating a new session if necessary. If leases are not enabled, an
+// empty ID is returned. The session is created without holding c.mu, so
+// that a slow request to Consul does not block the other operations of the
+// client. If concurrent writes both create a session, the first one
+// installed is used and the others are destroyed.
+func (c *Client) leaseSession(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	leaseTTL, sessionID := c.leaseTTL, c.sessionID
+	c.mu.Unlock()
+	if leaseTTL == 0 {
+		return "", nil
+	}
+	if sessionID != "" {
+		return sessionID, nil
+	}
+
+	ttl := leaseTTL.String()
+	wo := (&api.WriteOptions{}).WithContext(ctx)
+	id, _, err := c.session.Create(&api.SessionEntry{
+		Name:      c.leaderKey,
+		TTL:       ttl,
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: leaseLockDelay,
+	}, wo)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	if c.sessionID != "" {
+		sessionID = c.sessionID
+		c.mu.Unlock()
+		// The session expires with its TTL if it cannot be destroyed.
+		c.session.Destroy(id, wo)
+		return sessionID, nil
+	}
+	c.sessionID = id
+	c.mu.Unlock()
+	go c.renewSession(id, ttl)
+	return id, nil
+}
+
+// renewSession renews session id until it is invalidated or the client is
+// closed.
+func (c *Client) renewSession(id, ttl string) {
+	err := c.session.RenewPeriodic(ttl, id, (&api.WriteOptions{}).WithContext(c.leaseCtx), nil)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sessionID == id {
+		c.sessionID = ""
+	}
+	if c.leaseCtx.Err() != nil {
+		// The client is closing.
+		return
+	}
+	if err != nil {
+		err = fmt.Errorf("%w: %w", disco.ErrLeaseLost, err)
+	} else {
+		err = disco.ErrLeaseLost
+	}
+	select {
+	case c.leaseLostCh <- err:
+	default:
+	}
+}
+
+// lockKey writes b to key, locked by session sessionID, in a transaction.
+// If check is not nil, it is the first operation of the transaction, and ok
+// is false if it fails. The key is deleted before it is locked, releasing
+// any lock held on it by another session.
+func (c *Client) lockKey(ctx context.Context, key, sessionID string, b []byte, check *api.KVTxnOp) (bool, error) {
+	var ops api.KVTxnOps
+	if check != nil {
+		ops = append(ops, check)
+	}
+	ops = append(ops,
+		&api.KVTxnOp{Verb: api.KVDelete, Key: key},
+		&api.KVTxnOp{Verb: api.KVLock, Key: key, Value: b, Session: sessionID},
+	)
+	ok, resp, _, err := c.client.Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return true, nil
+	}
+	for _, e := range resp.Errors {
+		if check == nil || e.OpIndex != 0 {
+			return false, fmt.Errorf("%w: failed to lock %s: %s", disco.ErrConflict, key, e.What)
+		}
+	}
+	return false, nil
+}
+
+// WatchLeader returns a channel on which changes to the leader record are
+// sent, using blocking queries on the leader key. If a leader is recorded
+// when the watch starts, it is sent as the first event. The channel is
+// closed once ctx is done.
+func (c *Client) WatchLeader(ctx context.Context) <-chan disco.LeaderEvent {
+	ch := make(chan disco.LeaderEvent)
+	go c.watchLeader(ctx, ch)
+	return ch
+}
+
+func (c *Client) watchLeader(ctx context.Context, ch chan<- disco.LeaderEvent) {
+	defer close(ch)
+
+	// lastModify is the ModifyIndex of the last record sent, or 0 if no
+	// record is present.
+	var index, lastModify uint64
+	for {
+		opts := &api.QueryOptions{WaitIndex: index}
+		pair, meta, err := c.client.Get(c.leaderKey, opts.WithContext(ctx))
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			if !sendEvent(ctx, ch, disco.LeaderEvent{Err: c.wrapError("watch_leader", err)}) || !sleepContext(ctx, watchRetryInterval) {
+				return
+			}
+			continue
+		}
+
+		// The index can go backwards, for example after a snapshot restore,
+		// in which case the blocking query must be restarted.
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+
+		if pair == nil {
+			if lastModify != 0 {
+				if !sendEvent(ctx, ch, disco.LeaderEvent{Deleted: true}) {
+					return
+				}
+				lastModify = 0
+			}
+		} else if pair.ModifyIndex != lastModify {
+			if !sendEvent(ctx, ch, c.leaderEvent(pair.Value)) {
+				return
+			}
+			lastModify = pair.ModifyIndex
+		}
+	}
+}
+
+// do calls fn, retrying it according to the retry policy of the client and
+// within its timeout, in a span for operation op, and records the outcome.
+// The error returned by the last attempt is wrapped in a *disco.Error if its
+// cause is known.
+func (c *Client) do(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	ctx, span := tracing.Start(ctx, c.tracer, c.String(), op, tracing.KeyKey.String(c.key))
+	start := c.clock.Now()
+	retries, err := c.retryPolicy.Do(ctx, isRetryable, fn)
+	err = c.wrapError(op, err)
+	tracing.End(span, err)
+	c.recordOp(op, start, retries, err)
+	return err
+}
+
+// recordOp records the outcome of operation op, which started at start, in
+// the statistics and metrics of the client, and logs it.
+func (c *Client) recordOp(op string, start time.Time, retries int, err error) {
+	c.stats.Record(op, start, retries, err)
+	d := c.clock.Now().Sub(start)
+	c.metrics.ObserveOp(c.String(), op, d, err)
+	if err != nil {
+		c.logger.Debug("operation failed", logging.KeyOp, op, logging.KeyRetries, retries,
+			logging.KeyDuration, d, logging.KeyError, err)
+		return
+	}
+	c.logger.Debug("operation completed", logging.KeyOp, op, logging.KeyRetries, retries,
+		logging.KeyDuration, d)
+}
+
+// recordCAS records the result of check-and-set operation op, which wrote
+// the leader record of id if ok is true, in the metrics of the client, and
+// logs it. Failed operations are recorded by recordOp.
+func (c *Client) recordCAS(op, id string, ok bool, err error) {
+	if err != nil {
+		return
+	}
+	c.metrics.ObserveCAS(c.String(), op, ok)
+	if ok {
+		c.logger.Info("compare-and-set", logging.KeyOp, op, logging.KeyLeaderID, id,
+			logging.KeyResult, logging.ResultOK)
+		return
+	}
+	c.logger.Debug("compare-and-set", logging.KeyOp, op, logging.KeyLeaderID, id,
+		logging.KeyResult, logging.ResultConflict)
+}
+
+// Stats returns diagnostics information about the client, including the
+// time of the last successful request to Consul, the last error, the count and
+// latency of each kind of operation, and the last leader read from Consul.
+func (c *Client) Stats() (map[string]interface{}, error) {
+	stats := map[string]interface{}{
+		"mode":      "consul-kv",
+		"endpoints": []string{c.address},
+		"key":       c.key,
+	}
+	c.stats.AddTo(stats)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastLeader != (disco.Leader{}) {
+		stats["last_leader"] = map[string]interface{}{
+			"id":       c.lastLeader.ID,
+			"api_addr": c.lastLeader.APIAddr,
+			"addr":     c.lastLeader.Addr,
+		}
+	}
+	return stats, nil
+}
+
+// errorKind returns the sentinel error classifying err, returned by a
+// request to Consul, or nil if the cause of err is not known.
+func errorKind(err error) error {
+	for _, kind := range []error{disco.ErrCorruptRecord, disco.ErrConflict} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	var statusErr api.StatusError
+	if errors.As(err, &statusErr) {
+		switch {
+		case statusErr.Code == http.StatusNotFound:
+			return disco.ErrNotFound
+		case statusErr.Code == http.StatusUnauthorized || statusErr.Code == http.StatusForbidden:
+			return disco.ErrPermissionDenied
+		case statusErr.Code == http.StatusConflict:
+			return disco.ErrConflict
+		case statusErr.Code >= http.StatusInternalServerError || statusErr.Code == http.StatusTooManyRequests:
+			return disco.ErrUnavailable
+		}
+		return nil
+	}
+	if retry.Transient(err) {
+		return disco.ErrUnavailable
+	}
+	return nil
+}
+
+// isRetryable returns whether err, returned by a request to Consul, is
+// likely to be transient, which is the case if it indicates that Consul is
+// unreachable, overloaded or unable to serve the request, for example
+// because it has no leader.
+func isRetryable(err error) bool {
+	return errorKind(err) == disco.ErrUnavailable
+}
+
+// wrapError wraps err, returned by operation op, in a *disco.Error if its
+// cause is known.
+func (c *Client) wrapError(op string, err error) error {
+	return disco.WrapError(c.String(), op, errorKind(err), err)
+}
+
+// sawLeader records l as the last leader seen.
+func (c *Client) sawLeader(l disco.Leader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l != c.lastLeader {
+		c.logger.Info("leader changed", logging.KeyLeaderID, l.ID)
+	}
+	c.lastLeader = l
+}
+
 // String implements the Stringer interface.
 func (c *Client) String() string {
 	return "consul-kv"
 }
 
-// Close closes the client.
+// Close closes the client. If leader leases are enabled, the session is no
+// longer renewed, and any leader record bound to it expires once its TTL
+// elapses. The same applies to leadership acquired by Campaign, which
+// should be given up with Resign first if it is not to be held until then.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.election != nil {
+		c.election.stop()
+		c.election = nil
+	}
+	if c.leaseCancel != nil {
+		c.leaseCancel()
+		close(c.leaseLostCh)
+		c.leaseCancel = nil
+	}
 	return nil
 }
 
 type node struct {
-	ID      string `json:"id,omitempty"`
-	APIAddr string `json:"api_addr,omitempty"`
-	Addr    string `json:"addr,omitempty"` // Needs TLS settings, etc I think so anyway. Maybe join handles?
+	ID        string    `json:"id,omitempty"`
+	APIAddr   string    `json:"api_addr,omitempty"`
+	Addr      string    `json:"addr,omitempty"` // Needs TLS settings, etc I think so anyway. Maybe join handles?
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (n node) leader() disco.Leader {
+	return disco.Leader{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr}
+}
+
+// newNode returns the record of a node with the given details, written now.
+func (c *Client) newNode(id, apiAddr, addr string) node {
+	return node{ID: id, APIAddr: apiAddr, Addr: addr, UpdatedAt: c.clock.Now().UTC()}
+}
+
+// isLeaderRecord returns whether the leader record is n, as written by this
+// client, comparing both the leader and the time of the write.
+func (c *Client) isLeaderRecord(ctx context.Context, n node) (bool, error) {
+	rec, ok, err := c.getLeaderRecord(ctx)
+	if err != nil || !ok {
+		return false, err
+	}
+	return rec.Leader == n.leader() && rec.UpdatedAt.Equal(n.UpdatedAt), nil
+}
+
+// noLeaderRecord returns whether there is no leader record, as left by a
+// retried ResignLeaderContext whose earlier attempt deleted it.
+func (c *Client) noLeaderRecord(ctx context.Context) (bool, error) {
+	_, ok, err := c.getLeaderRecord(ctx)
+	return !ok && err == nil, err
+}
+
+// decodeNode decodes the node record b into n.
+func decodeNode(b []byte, n *node) error {
+	if err := json.Unmarshal(b, n); err != nil {
+		return fmt.Errorf("%w: %w", disco.ErrCorruptRecord, err)
+	}
+	return nil
+}
+
+// leaderEvent returns the event for a leader record with the given value,
+// recording the leader as seen.
+func (c *Client) leaderEvent(b []byte) disco.LeaderEvent {
+	n := node{}
+	if err := decodeNode(b, &n); err != nil {
+		return disco.LeaderEvent{Err: c.wrapError("watch_leader", err)}
+	}
+	c.sawLeader(n.leader())
+	return disco.LeaderEvent{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr}
+}
+
+// sendEvent sends e on ch, returning false if ctx is done first.
+func sendEvent(ctx context.Context, ch chan<- disco.LeaderEvent, e disco.LeaderEvent) bool {
+	select {
+	case ch <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleepContext pauses for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
 
 func consulConfigFromClientConfig(cfg *Config) *api.Config {
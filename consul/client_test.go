@@ -1,13 +1,31 @@
 package consul
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log/slog"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/disco/disctest"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_NewClient(t *testing.T) {
@@ -125,70 +143,668 @@ func Test_NewClientConfigConnectFail(t *testing.T) {
 	}
 }
 
-func Test_InitializeLeader(t *testing.T) {
-	c, _ := New(randomString(), nil)
+func Test_LeaderStoreSuite(t *testing.T) {
+	disctest.RunLeaderStoreSuite(t, func(t *testing.T, key string) disco.LeaderStore {
+		c, err := New(key, nil)
+		if err != nil {
+			t.Fatalf("failed to create new client: %s", err.Error())
+		}
+		return c
+	})
+}
+
+func Test_NodeRegistrySuite(t *testing.T) {
+	disctest.RunNodeRegistrySuite(t, func(t *testing.T, key string) disco.LeaderStore {
+		c, err := New(key, nil)
+		if err != nil {
+			t.Fatalf("failed to create new client: %s", err.Error())
+		}
+		return c
+	})
+}
+
+func Test_WatchLeader(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
 	defer c.Close()
-	_, _, _, ok, err := c.GetLeader()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := c.WatchLeader(ctx)
+
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"})
+
+	if err := c.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"})
+
+	if err := c.DeleteLeader(); err != nil {
+		t.Fatalf("error when deleting leader: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{Deleted: true})
+
+	cancel()
+	testutil.MustBeClosed(t, ch)
+}
+
+func Test_WatchLeaderExisting(t *testing.T) {
+	c, err := New(randomString(), nil)
 	if err != nil {
-		t.Fatalf("failed to GetLeader: %s", err.Error())
+		t.Fatalf("failed to create new client: %s", err.Error())
 	}
-	if ok {
-		t.Fatalf("leader found when not expected")
+	defer c.Close()
+
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := c.WatchLeader(ctx)
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"})
+}
+
+func Test_LeaderLease(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
 	}
+	defer c.Close()
+	lostCh := c.EnableLeaderLease(10 * time.Second)
 
-	ok, err = c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
 	if err != nil {
 		t.Fatalf("error when initializing leader: %s", err.Error())
 	}
 	if !ok {
 		t.Fatalf("failed to initialize leader")
 	}
+	ok, err = c.InitializeLeader("2", "http://localhost:4003", "localhost:4004")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("initialized leader when should have failed")
+	}
+	pair, _, err := c.client.Get(c.leaderKey, nil)
+	if err != nil {
+		t.Fatalf("failed to get leader key: %s", err.Error())
+	}
+	sessionID := pair.Session
+	if sessionID == "" {
+		t.Fatalf("leader record not bound to a session")
+	}
 
-	id, api, addr, ok, err := c.GetLeader()
+	// Destroying the session simulates it expiring.
+	if _, err := c.session.Destroy(sessionID, nil); err != nil {
+		t.Fatalf("failed to destroy session: %s", err.Error())
+	}
+	_, _, _, ok, err = c.GetLeader()
 	if err != nil {
 		t.Fatalf("failed to GetLeader: %s", err.Error())
 	}
-	if !ok {
-		t.Fatalf("leader not found when expected")
+	if ok {
+		t.Fatalf("leader found after session was destroyed")
+	}
+	select {
+	case err := <-lostCh:
+		if !errors.Is(err, disco.ErrLeaseLost) {
+			t.Fatalf("wrong error received, exp %v, got %v", disco.ErrLeaseLost, err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatalf("timed out waiting for lease loss")
+	}
+
+	// The next write must be bound to a new session.
+	if err := c.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	pair, _, err = c.client.Get(c.leaderKey, nil)
+	if err != nil {
+		t.Fatalf("failed to get leader key: %s", err.Error())
+	}
+	if pair.Session == "" || pair.Session == sessionID {
+		t.Fatalf("leader record not bound to a new session")
+	}
+}
+
+func Test_RegisterNodeLease(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+	c.EnableLeaderLease(10 * time.Second)
+
+	if err := c.RegisterNode("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	pair, _, err := c.client.Get(c.nodesKey+"1", nil)
+	if err != nil {
+		t.Fatalf("failed to get node key: %s", err.Error())
+	}
+	if pair.Session == "" {
+		t.Fatalf("node record not bound to a session")
+	}
+
+	// Destroying the session simulates the node going away.
+	if _, err := c.session.Destroy(pair.Session, nil); err != nil {
+		t.Fatalf("failed to destroy session: %s", err.Error())
+	}
+	nodes, err := c.ListNodes()
+	if err != nil {
+		t.Fatalf("failed to ListNodes: %s", err.Error())
 	}
-	if id != "1" || api != "http://localhost:4001" || addr != "localhost:4002" {
-		t.Fatalf("retrieved incorrect details for leader")
+	if len(nodes) != 0 {
+		t.Fatalf("nodes found after session was destroyed: %+v", nodes)
 	}
 }
 
-func Test_InitializeLeaderConflict(t *testing.T) {
-	c, _ := New(randomString(), nil)
+func Test_LeaderLeaseConcurrentWrites(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
 	defer c.Close()
-	_, _, _, ok, err := c.GetLeader()
+	c.EnableLeaderLease(10 * time.Second)
+
+	// Concurrent writes must all be bound to the same session.
+	var wg sync.WaitGroup
+	errCh := make(chan error, 5)
+	for i := 1; i <= 5; i++ {
+		wg.Add(1)
+		go func(id string) {
+			defer wg.Done()
+			errCh <- c.RegisterNode(id, "http://localhost:4001", "localhost:4002")
+		}(fmt.Sprint(i))
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		if err != nil {
+			t.Fatalf("error when registering node: %s", err.Error())
+		}
+	}
+	pairs, _, err := c.client.List(c.nodesKey, nil)
+	if err != nil {
+		t.Fatalf("failed to list node keys: %s", err.Error())
+	}
+	if len(pairs) != 5 {
+		t.Fatalf("wrong number of nodes, exp 5, got %d", len(pairs))
+	}
+	for _, pair := range pairs {
+		if pair.Session == "" || pair.Session != pairs[0].Session {
+			t.Fatalf("node records not bound to the same session")
+		}
+	}
+
+	// Disabling leases must only affect subsequent writes.
+	c.EnableLeaderLease(0)
+	if err := c.RegisterNode("6", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	pair, _, err := c.client.Get(c.nodesKey+"6", nil)
+	if err != nil {
+		t.Fatalf("failed to get node key: %s", err.Error())
+	}
+	if pair.Session != "" {
+		t.Fatalf("node record bound to a session after leases were disabled")
+	}
+	pair, _, err = c.client.Get(c.nodesKey+"1", nil)
+	if err != nil {
+		t.Fatalf("failed to get node key: %s", err.Error())
+	}
+	if pair.Session != pairs[0].Session {
+		t.Fatalf("node record no longer bound to its session")
+	}
+}
+
+func Test_LeaderLeaseSetLeaderOverwrite(t *testing.T) {
+	key := randomString()
+	c1, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c1.Close()
+	c1.EnableLeaderLease(10 * time.Second)
+	c2, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c2.Close()
+	c2.EnableLeaderLease(10 * time.Second)
+
+	if err := c1.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	if err := c2.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	id, _, _, ok, err := c1.GetLeader()
 	if err != nil {
 		t.Fatalf("failed to GetLeader: %s", err.Error())
 	}
+	if !ok || id != "2" {
+		t.Fatalf("wrong leader, exp 2, got %s", id)
+	}
+}
+
+func Test_LeaderLeaseUpdateLeaderIf(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+	c.EnableLeaderLease(10 * time.Second)
+
+	l1 := disco.Leader{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}
+	l2 := disco.Leader{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	ok, err := c.UpdateLeaderIf(disco.Leader{}, l1)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to update leader when no leader was recorded")
+	}
+	ok, err = c.UpdateLeaderIf(l1, l2)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to update leader when record matched")
+	}
+	ok, err = c.UpdateLeaderIf(l1, l2)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
 	if ok {
-		t.Fatalf("leader found when not expected")
+		t.Fatalf("updated leader when record did not match")
 	}
+	mustGetLeader(t, c, "2")
+}
 
-	err = c.SetLeader("2", "http://localhost:4003", "localhost:4004")
+func Test_Logger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	key := randomString()
+	c, err := NewClient(key, nil, WithLogger(logger))
 	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002"); err != nil || !ok {
+		t.Fatalf("failed to initialize leader: %v %v", ok, err)
+	}
+	if ok, err := c.InitializeLeader("2", "http://localhost:4003", "localhost:4004"); err != nil || ok {
+		t.Fatalf("initialized leader twice: %v %v", ok, err)
+	}
+	mustGetLeader(t, c, "1")
+
+	for _, exp := range []string{
+		`"level":"INFO","msg":"compare-and-set","backend":"consul-kv","key":"` + key + `","op":"initialize_leader","leader_id":"1","result":"ok"`,
+		`"level":"DEBUG","msg":"compare-and-set","backend":"consul-kv","key":"` + key + `","op":"initialize_leader","leader_id":"2","result":"conflict"`,
+		`"msg":"operation completed","backend":"consul-kv","key":"` + key + `","op":"get_leader","retries":0`,
+		`"level":"INFO","msg":"leader changed","backend":"consul-kv","key":"` + key + `","leader_id":"1"`,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Fatalf("log does not contain %s: %s", exp, buf.String())
+		}
+	}
+}
+
+func Test_NewClientOptions(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var requests int32
+	httpClient := &http.Client{Transport: roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return http.DefaultTransport.RoundTrip(req)
+	})}
+	c, err := NewClient(randomString(), nil,
+		WithHTTPClient(httpClient),
+		WithClock(testutil.FixedClock(now)),
+		WithRetry(&retry.Policy{MaxAttempts: 2}),
+		WithTimeout(5*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
 		t.Fatalf("error when setting leader: %s", err.Error())
 	}
+	rec, ok, err := c.GetLeaderRecord()
+	if err != nil || !ok {
+		t.Fatalf("failed to get leader record: %v %v", ok, err)
+	}
+	if !rec.UpdatedAt.Equal(now) {
+		t.Fatalf("wrong UpdatedAt, exp %v, got %v", now, rec.UpdatedAt)
+	}
+	if atomic.LoadInt32(&requests) == 0 {
+		t.Fatalf("HTTP client was not used")
+	}
+}
 
-	ok, err = c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+func Test_NewFromConfig(t *testing.T) {
+	key := randomString()
+	store, err := disco.NewLeaderStoreFromConfig("consul-kv", strings.NewReader(`{"address": "127.0.0.1:8500"}`),
+		disco.WithKey(key), disco.WithTimeout(5*time.Second))
 	if err != nil {
-		t.Fatalf("error when initializing leader: %s", err.Error())
+		t.Fatalf("failed to create new client: %s", err.Error())
 	}
-	if ok {
-		t.Fatalf("initialized leader when should have failed")
+	defer store.Close()
+	if exp, got := "consul-kv", store.String(); exp != got {
+		t.Fatalf("wrong mode, exp %s, got %s", exp, got)
+	}
+	if exp, got := key, store.(*Client).key; exp != got {
+		t.Fatalf("wrong key, exp %s, got %s", exp, got)
+	}
+	if err := store.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustGetLeader(t, store.(*Client), "1")
+
+	if _, err := disco.NewFromConfig("consul-kv", strings.NewReader(`{`)); err == nil {
+		t.Fatalf("expected error for malformed config")
+	}
+}
+
+func Test_NewClientTimeout(t *testing.T) {
+	// The server never responds before the request is canceled.
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	c, err := NewClient(randomString(), &Config{Address: srv.Listener.Addr().String()},
+		WithTimeout(50*time.Millisecond))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if _, _, _, _, err := c.GetLeader(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
 	}
+}
+
+func Test_Metrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	c, err := NewClient(randomString(), nil, WithMetrics(reg))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002"); err != nil || !ok {
+		t.Fatalf("failed to initialize leader: %v %v", ok, err)
+	}
+	if ok, err := c.InitializeLeader("2", "http://localhost:4003", "localhost:4004"); err != nil || ok {
+		t.Fatalf("initialized leader twice: %v %v", ok, err)
+	}
+	mustGetLeader(t, c, "1")
+
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatalf("failed to write metrics: %s", err.Error())
+	}
+	for _, exp := range []string{
+		`disco_operations_total{backend="consul-kv",op="get_leader"} 1`,
+		`disco_operations_total{backend="consul-kv",op="initialize_leader"} 2`,
+		`disco_cas_total{backend="consul-kv",op="initialize_leader",result="conflict"} 1`,
+		`disco_cas_total{backend="consul-kv",op="initialize_leader",result="ok"} 1`,
+		`disco_operation_duration_seconds_count{backend="consul-kv",op="initialize_leader"} 2`,
+	} {
+		if !strings.Contains(b.String(), exp) {
+			t.Fatalf("metrics do not contain %s:\n%s", exp, b.String())
+		}
+	}
+}
+
+func Test_Tracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	key := randomString()
+	c, err := NewClient(key, nil, WithTracerProvider(tp))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if _, _, _, ok, err := c.GetLeader(); err != nil || ok {
+		t.Fatalf("unexpected leader: %v %v", ok, err)
+	}
+	if ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002"); err != nil || !ok {
+		t.Fatalf("failed to initialize leader: %v %v", ok, err)
+	}
+	if ok, err := c.InitializeLeader("2", "http://localhost:4003", "localhost:4004"); err != nil || ok {
+		t.Fatalf("initialized leader twice: %v %v", ok, err)
+	}
+	if err := c.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustGetLeader(t, c, "2")
+
+	testCases := []struct {
+		name   string
+		result string
+		count  int64
+	}{
+		{"consul-kv.get_leader", "not_found", 0},
+		{"consul-kv.initialize_leader", "ok", -1},
+		{"consul-kv.initialize_leader", "conflict", -1},
+		{"consul-kv.set_leader", "ok", -1},
+		{"consul-kv.get_leader", "ok", 1},
+	}
+	spans := sr.Ended()
+	if exp, got := len(testCases), len(spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	for i, tc := range testCases {
+		span := spans[i]
+		if span.Name() != tc.name {
+			t.Fatalf("wrong name of span %d, exp %s, got %s", i, tc.name, span.Name())
+		}
+		if v, _ := testutil.SpanAttr(span, "disco.key"); v.AsString() != key {
+			t.Fatalf("wrong key of span %s, exp %s, got %v", tc.name, key, v.Emit())
+		}
+		if v, _ := testutil.SpanAttr(span, "disco.result"); v.AsString() != tc.result {
+			t.Fatalf("wrong result of span %s, exp %s, got %v", tc.name, tc.result, v.Emit())
+		}
+		if tc.count < 0 {
+			continue
+		}
+		if v, _ := testutil.SpanAttr(span, "disco.record_count"); v.AsInt64() != tc.count {
+			t.Fatalf("wrong record count of span %s, exp %d, got %v", tc.name, tc.count, v.Emit())
+		}
+	}
+}
+
+func Test_Stats(t *testing.T) {
+	key := randomString()
+	c, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
 
-	id, api, addr, ok, err := c.GetLeader()
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if got, exp := stats["mode"], "consul-kv"; got != exp {
+		t.Fatalf("wrong mode, exp %v, got %v", exp, got)
+	}
+	if got, exp := stats["key"], key; got != exp {
+		t.Fatalf("wrong key, exp %v, got %v", exp, got)
+	}
+	if eps := stats["endpoints"].([]string); len(eps) != 1 || eps[0] != "127.0.0.1:8500" {
+		t.Fatalf("wrong endpoints, got %v", eps)
+	}
+	for _, k := range []string{"last_contact", "last_error", "operations", "last_leader"} {
+		if _, ok := stats[k]; ok {
+			t.Fatalf("stats contain %s before any operation", k)
+		}
+	}
+
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustGetLeader(t, c, "1")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, _, err := c.GetLeaderContext(ctx); err == nil {
+		t.Fatalf("expected error for canceled context")
+	}
+
+	stats, err = c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if _, ok := stats["last_contact"].(time.Time); !ok {
+		t.Fatalf("last_contact missing from stats: %v", stats)
+	}
+	if _, ok := stats["last_error"].(string); !ok {
+		t.Fatalf("last_error missing from stats: %v", stats)
+	}
+	ops := stats["operations"].(map[string]interface{})
+	getLeader := ops["get_leader"].(map[string]interface{})
+	if got, exp := getLeader["count"], uint64(2); got != exp {
+		t.Fatalf("wrong get_leader count, exp %v, got %v", exp, got)
+	}
+	if got, exp := getLeader["errors"], uint64(1); got != exp {
+		t.Fatalf("wrong get_leader errors, exp %v, got %v", exp, got)
+	}
+	setLeader := ops["set_leader"].(map[string]interface{})
+	if got, exp := setLeader["count"], uint64(1); got != exp {
+		t.Fatalf("wrong set_leader count, exp %v, got %v", exp, got)
+	}
+	leader := stats["last_leader"].(map[string]interface{})
+	if got, exp := leader["id"], "1"; got != exp {
+		t.Fatalf("wrong last leader, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_Retry(t *testing.T) {
+	// Nothing listens on this port, so every request is refused.
+	c, err := New(randomString(), &Config{
+		Address: "127.0.0.1:1",
+		Retry:   &retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if _, _, _, _, err := c.GetLeader(); err == nil {
+		t.Fatalf("expected error from unreachable Consul")
+	}
+	stats, err := c.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	getLeader := stats["operations"].(map[string]interface{})["get_leader"].(map[string]interface{})
+	if got, exp := getLeader["retries"], uint64(2); got != exp {
+		t.Fatalf("wrong get_leader retries, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_IsRetryable(t *testing.T) {
+	testCases := []struct {
+		err error
+		exp bool
+	}{
+		{api.StatusError{Code: http.StatusInternalServerError}, true},
+		{api.StatusError{Code: http.StatusTooManyRequests}, true},
+		{api.StatusError{Code: http.StatusForbidden}, false},
+		{context.Canceled, false},
+		{errors.New("boom"), false},
+	}
+	for _, tc := range testCases {
+		if got := isRetryable(tc.err); got != tc.exp {
+			t.Fatalf("wrong result for %v, exp %v, got %v", tc.err, tc.exp, got)
+		}
+	}
+}
+
+func Test_ErrorKind(t *testing.T) {
+	testCases := []struct {
+		err error
+		exp error
+	}{
+		{api.StatusError{Code: http.StatusNotFound}, disco.ErrNotFound},
+		{api.StatusError{Code: http.StatusForbidden}, disco.ErrPermissionDenied},
+		{api.StatusError{Code: http.StatusUnauthorized}, disco.ErrPermissionDenied},
+		{api.StatusError{Code: http.StatusConflict}, disco.ErrConflict},
+		{api.StatusError{Code: http.StatusInternalServerError}, disco.ErrUnavailable},
+		{api.StatusError{Code: http.StatusTooManyRequests}, disco.ErrUnavailable},
+		{api.StatusError{Code: http.StatusBadRequest}, nil},
+		{fmt.Errorf("%w: bad json", disco.ErrCorruptRecord), disco.ErrCorruptRecord},
+		{context.Canceled, nil},
+		{errors.New("boom"), nil},
+	}
+	for _, tc := range testCases {
+		if got := errorKind(tc.err); got != tc.exp {
+			t.Fatalf("wrong kind for %v, exp %v, got %v", tc.err, tc.exp, got)
+		}
+	}
+}
+
+func Test_ErrorUnavailable(t *testing.T) {
+	// Nothing listens on this port, so every request is refused.
+	c, err := New(randomString(), &Config{Address: "127.0.0.1:1"})
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	_, _, _, _, err = c.GetLeader()
+	if !errors.Is(err, disco.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+	var discoErr *disco.Error
+	if !errors.As(err, &discoErr) {
+		t.Fatalf("expected *disco.Error, got %T", err)
+	}
+	if exp, got := "get_leader", discoErr.Op; exp != got {
+		t.Fatalf("wrong op, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_ErrorCorruptRecord(t *testing.T) {
+	c, err := New(randomString(), nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c.Close()
+
+	if _, err := c.client.Put(&api.KVPair{Key: c.leaderKey, Value: []byte("{bad")}, nil); err != nil {
+		t.Fatalf("failed to write leader key: %s", err.Error())
+	}
+	_, _, _, _, err = c.GetLeader()
+	if !errors.Is(err, disco.ErrCorruptRecord) {
+		t.Fatalf("expected ErrCorruptRecord, got %v", err)
+	}
+}
+
+func mustGetLeader(t *testing.T, c *Client, expID string) {
+	t.Helper()
+	id, _, _, ok, err := c.GetLeader()
 	if err != nil {
 		t.Fatalf("failed to GetLeader: %s", err.Error())
 	}
 	if !ok {
 		t.Fatalf("leader not found when expected")
 	}
-	if id != "2" || api != "http://localhost:4003" || addr != "localhost:4004" {
-		t.Fatalf("retrieved incorrect details for leader")
+	if id != expID {
+		t.Fatalf("wrong leader, exp %s, got %s", expID, id)
 	}
 }
 
@@ -224,3 +840,9 @@ func mustTempFile() string {
 	tmpfile.Close()
 	return tmpfile.Name()
 }
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
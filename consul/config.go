@@ -1,5 +1,13 @@
 package consul
 
+import (
+	"strings"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
 const (
 	// exampleConfig is an example of how the Consul config file
 	// should be structured.
@@ -18,6 +26,11 @@ const (
 	"partition": "my_partition",
 	"tls_config": {
 		"insecure_skip_verify": true
+	},
+	"retry": {
+		"max_attempts": 5,
+		"initial_backoff": 100000000,
+		"deadline": 10000000000
 	}
 }
 `
@@ -27,6 +40,12 @@ const (
 type BasicAuthConfig struct {
 	Username string `json:"username,omitempty"`
 	Password string `json:"password,omitempty"`
+
+	// PasswordFile is a file containing the password, such as a mounted
+	// secret. If it is set, the file is read when the config is loaded by
+	// NewConfigFromFile or NewConfigFromReader, and its contents, without
+	// trailing newlines, set Password, which must not be set as well.
+	PasswordFile string `json:"password_file,omitempty"`
 }
 
 // TLSConfig sets the configuration for TLS communication with Consul.
@@ -100,4 +119,66 @@ type Config struct {
 
 	// TLSConfig is the TLS config for talking to Consul
 	TLSConfig *TLSConfig `json:"tls_config,omitempty"`
+
+	// Retry is the policy for retrying requests to Consul which fail with
+	// transient errors. Requests are not retried if it is not set. If the
+	// check of a retried check-and-set fails, the leader record is read
+	// again, and the operation succeeds if the record is the one written by
+	// an earlier attempt whose response was lost, or, for ResignLeader, if
+	// the record is gone.
+	Retry *retry.Policy `json:"retry,omitempty"`
+}
+
+// Validate checks the values of c, returning a *disco.ConfigError listing
+// every invalid field. A nil Config is valid.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	var v disco.Validator
+	if strings.HasPrefix(c.Address, "http") {
+		v.Add("address", "should not contain HTTP or HTTPS")
+	}
+	switch c.Scheme {
+	case "", "http", "https":
+	default:
+		v.Add("scheme", "must be http or https")
+	}
+	if b := c.BasicAuth; b != nil && b.Username == "" && (b.Password != "" || b.PasswordFile != "") {
+		v.Add("basic_auth.username", "must be set with password")
+	}
+	if b := c.BasicAuth; b != nil && b.Password != "" && b.PasswordFile != "" {
+		v.Add("basic_auth.password_file", "must not be set with password")
+	}
+	if t := c.TLSConfig; t != nil {
+		if t.CertFile != "" && t.KeyFile == "" {
+			v.Add("tls_config.key_file", "must be set with cert_file")
+		}
+		if t.KeyFile != "" && t.CertFile == "" {
+			v.Add("tls_config.cert_file", "must be set with key_file")
+		}
+		if len(t.CertPEM) > 0 && len(t.KeyPEM) == 0 {
+			v.Add("tls_config.key_pem", "must be set with cert_pem")
+		}
+		if len(t.KeyPEM) > 0 && len(t.CertPEM) == 0 {
+			v.Add("tls_config.cert_pem", "must be set with key_pem")
+		}
+	}
+	v.Nested("retry", c.Retry.Validate())
+	return v.Err()
+}
+
+// readFiles sets the fields of c which are read from files, returning a
+// *disco.ConfigError listing the files which cannot be read.
+func (c *Config) readFiles() error {
+	var v disco.Validator
+	if c.BasicAuth != nil && c.BasicAuth.PasswordFile != "" {
+		password, err := expand.ReadFile(c.BasicAuth.PasswordFile)
+		if err != nil {
+			v.Add("basic_auth.password_file", "%s", err)
+		} else {
+			c.BasicAuth.Password = password
+		}
+	}
+	return v.Err()
 }
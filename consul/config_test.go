@@ -1,8 +1,17 @@
 package consul
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/retry"
 )
 
 const (
@@ -10,6 +19,35 @@ const (
 {
 	"address": "http://1.2.3.4"
 }
+`
+
+	yamlConfig = `
+address: ${CONSUL_ADDRESS}
+scheme: https
+basic_auth:
+  username: me
+  password: my password
+tls_config:
+  insecure_skip_verify: true
+retry:
+  max_attempts: 5
+  initial_backoff: 100000000
+`
+
+	tomlConfig = `
+address = "${CONSUL_ADDRESS}"
+scheme = "https"
+
+[basic_auth]
+username = "me"
+password = "my password"
+
+[tls_config]
+insecure_skip_verify = true
+
+[retry]
+max_attempts = 5
+initial_backoff = 100000000
 `
 )
 
@@ -32,6 +70,10 @@ func Test_LoadExampleConfig(t *testing.T) {
 	if cfg == nil {
 		t.Fatalf("nil config")
 	}
+
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 5 {
+		t.Fatalf("retry policy not parsed: %+v", cfg.Retry)
+	}
 }
 
 func Test_LoadBadConfigHTTP(t *testing.T) {
@@ -41,3 +83,196 @@ func Test_LoadBadConfigHTTP(t *testing.T) {
 		t.Fatalf("bad HTTP config unexpectedly parsed without error")
 	}
 }
+
+func Test_LoadYAMLTOMLConfig(t *testing.T) {
+	t.Setenv("CONSUL_ADDRESS", "1.2.3.4:8500")
+	for name, data := range map[string]string{"YAML": yamlConfig, "TOML": tomlConfig} {
+		cfg, err := NewConfigFromReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", name, err.Error())
+		}
+		checkConfig(t, name, cfg)
+	}
+}
+
+func Test_LoadConfigFileFormats(t *testing.T) {
+	t.Setenv("CONSUL_ADDRESS", "1.2.3.4:8500")
+	dir := t.TempDir()
+	for file, data := range map[string]string{
+		"disco.yml":  yamlConfig,
+		"disco.toml": tomlConfig,
+		"disco.conf": tomlConfig,
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write config file: %s", err.Error())
+		}
+		cfg, err := NewConfigFromFile(path)
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", file, err.Error())
+		}
+		checkConfig(t, file, cfg)
+	}
+}
+
+func Test_LoadBadConfigHTTPYAML(t *testing.T) {
+	_, err := NewConfigFromReader(strings.NewReader("address: http://1.2.3.4\n"))
+	if err == nil {
+		t.Fatalf("bad HTTP config unexpectedly parsed without error")
+	}
+}
+
+func checkConfig(t *testing.T, name string, cfg *Config) {
+	t.Helper()
+	if cfg.Address != "1.2.3.4:8500" || cfg.Scheme != "https" {
+		t.Fatalf("address not parsed from %s: %+v", name, cfg)
+	}
+	if cfg.BasicAuth == nil || cfg.BasicAuth.Username != "me" || cfg.BasicAuth.Password != "my password" {
+		t.Fatalf("basic auth not parsed from %s: %+v", name, cfg.BasicAuth)
+	}
+	if cfg.TLSConfig == nil || !cfg.TLSConfig.InsecureSkipVerify {
+		t.Fatalf("TLS config not parsed from %s: %+v", name, cfg.TLSConfig)
+	}
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 5 || cfg.Retry.InitialBackoff != 100*time.Millisecond {
+		t.Fatalf("retry policy not parsed from %s: %+v", name, cfg.Retry)
+	}
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	var cfg *Config
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("nil config is invalid: %s", err)
+	}
+	cfg = &Config{
+		Address:   "localhost:8500",
+		Scheme:    "https",
+		BasicAuth: &BasicAuthConfig{Username: "me", Password: "my password"},
+		TLSConfig: &TLSConfig{CertFile: "cert.pem", KeyFile: "key.pem"},
+		Retry:     &retry.Policy{MaxAttempts: 3},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid config is invalid: %s", err)
+	}
+
+	cfg = &Config{
+		Address:   "https://localhost:8500",
+		Scheme:    "ftp",
+		BasicAuth: &BasicAuthConfig{Password: "my password"},
+		TLSConfig: &TLSConfig{KeyFile: "key.pem", CertPEM: []byte("cert")},
+		Retry:     &retry.Policy{MaxAttempts: -1},
+	}
+	err := cfg.Validate()
+	exp := []string{
+		"address",
+		"scheme",
+		"basic_auth.username",
+		"tls_config.cert_file",
+		"tls_config.key_pem",
+		"retry.max_attempts",
+	}
+	if got := testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	if !strings.Contains(err.Error(), "retry.max_attempts: must not be negative") {
+		t.Fatalf("error does not describe problem: %s", err)
+	}
+}
+
+func Test_LoadBadConfigFileHTTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "disco.json")
+	if err := os.WriteFile(path, []byte(badConfigHTTP), 0644); err != nil {
+		t.Fatalf("failed to write config file: %s", err.Error())
+	}
+	_, err := NewConfigFromFile(path)
+	if exp := []string{"address"}; !reflect.DeepEqual(exp, testutil.InvalidFields(t, err)) {
+		t.Fatalf("bad HTTP config unexpectedly parsed without error: %v", err)
+	}
+}
+
+func Test_LoadConfigEnvPasswordEscaped(t *testing.T) {
+	password := "p\"a\\ss\nword\", \"address\": \"http://evil"
+	t.Setenv("CONSUL_PASSWORD", password)
+	cfg, err := NewConfigFromReader(strings.NewReader(`
+{
+	"address": "localhost:8500",
+	"basic_auth": {"username": "me", "password": "${CONSUL_PASSWORD}"}
+}`))
+	if err != nil {
+		t.Fatalf("failed to generate config: %s", err.Error())
+	}
+	if cfg.Address != "localhost:8500" || cfg.BasicAuth.Password != password {
+		t.Fatalf("password not substituted as is: %+v %q", cfg, cfg.BasicAuth.Password)
+	}
+}
+
+func Test_LoadConfigStrictEnv(t *testing.T) {
+	t.Setenv("CONSUL_ADDR", "localhost:8500")
+	data := `{"address": "${CONSUL_ADDRESS}", "scheme": "${CONSUL_SCHEME:-http}", "token": "$CONSUL_TOKEN"}`
+
+	cfg, err := NewConfigFromReader(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to generate config: %s", err.Error())
+	}
+	if cfg.Address != "" || cfg.Scheme != "http" {
+		t.Fatalf("wrong config generated: %+v", cfg)
+	}
+
+	_, err = NewConfigFromReader(strings.NewReader(data), expand.Strict())
+	var undefinedErr *expand.UndefinedError
+	if !errors.As(err, &undefinedErr) {
+		t.Fatalf("expected *expand.UndefinedError, got %v", err)
+	}
+	if exp := []string{"CONSUL_ADDRESS", "CONSUL_TOKEN"}; !reflect.DeepEqual(exp, undefinedErr.Names) {
+		t.Fatalf("wrong undefined variables, exp %v, got %v", exp, undefinedErr.Names)
+	}
+
+	path := filepath.Join(t.TempDir(), "disco.yaml")
+	if err := os.WriteFile(path, []byte("address: ${CONSUL_ADDRESS:?not set}\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %s", err.Error())
+	}
+	if _, err := NewConfigFromFile(path); err == nil || err.Error() != "CONSUL_ADDRESS: not set" {
+		t.Fatalf("expected error for required variable, got %v", err)
+	}
+}
+
+func Test_LoadConfigFiles(t *testing.T) {
+	dir := t.TempDir()
+	for file, data := range map[string]string{"password": "my password\n", "token": "my_token\n"} {
+		if err := os.WriteFile(filepath.Join(dir, file), []byte(data), 0600); err != nil {
+			t.Fatalf("failed to write file: %s", err.Error())
+		}
+	}
+	t.Setenv("SECRETS_DIR", dir)
+
+	cfg, err := NewConfigFromReader(strings.NewReader(`
+basic_auth:
+  username: me
+  password_file: ${SECRETS_DIR}/password
+token: ${file:${SECRETS_DIR}/token}
+`))
+	if err != nil {
+		t.Fatalf("failed to generate config: %s", err.Error())
+	}
+	if exp, got := "my password", cfg.BasicAuth.Password; exp != got {
+		t.Fatalf("wrong password, exp %q, got %q", exp, got)
+	}
+	if exp, got := "my_token", cfg.Token; exp != got {
+		t.Fatalf("wrong token, exp %q, got %q", exp, got)
+	}
+
+	_, err = NewConfigFromReader(strings.NewReader(`{"basic_auth": {"username": "me", "password_file": "/nonexistent/password"}}`))
+	if exp, got := []string{"basic_auth.password_file"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	if !strings.Contains(err.Error(), "basic_auth.password_file: open /nonexistent/password: no such file or directory") {
+		t.Fatalf("error does not name missing file: %s", err)
+	}
+	_, err = NewConfigFromReader(strings.NewReader(`{"basic_auth": {"username": "me", "password": "secret", "password_file": "${SECRETS_DIR}/password"}}`))
+	if exp, got := []string{"basic_auth.password_file"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	_, err = NewConfigFromReader(strings.NewReader(`{"token": "${file:/nonexistent/token}"}`))
+	if err == nil || !strings.Contains(err.Error(), "${file:/nonexistent/token}: open /nonexistent/token") {
+		t.Fatalf("expected error naming missing file, got %v", err)
+	}
+}
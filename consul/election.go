@@ -0,0 +1,277 @@
+package consul
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+const (
+	// defaultElectionTTL is the default TTL of the sessions which hold
+	// leadership acquired by Campaign.
+	defaultElectionTTL = 15 * time.Second
+
+	// defaultElectionLockDelay is the default lock-delay of the sessions
+	// which hold leadership acquired by Campaign. It is the same as the
+	// Consul default.
+	defaultElectionLockDelay = 15 * time.Second
+)
+
+// election is the state of a single call to Campaign.
+type election struct {
+	sessionID string
+	ttl       time.Duration
+	lostCh    chan struct{}
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// stop cancels any requests made on behalf of the election, and closes the
+// channel returned by Campaign.
+func (e *election) stop() {
+	e.stopOnce.Do(func() {
+		e.cancel()
+		close(e.lostCh)
+	})
+}
+
+// SetElectionTiming sets the TTL and lock-delay of the sessions created by
+// subsequent calls to Campaign. Consul requires the TTL to be between 10s
+// and 24h, and the lock-delay to be at most 60s.
+func (c *Client) SetElectionTiming(ttl, lockDelay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.electionTTL = ttl
+	c.electionLockDelay = lockDelay
+}
+
+// Campaign blocks until this client holds leadership of the key, or ctx is
+// done. Leadership is held by acquiring the leader key, with the given
+// details as the leader record, using a Consul session which is renewed in
+// the background. The session has the delete behavior, so the leader record
+// is removed if the session is invalidated, for example because this
+// process has crashed. Consul then refuses to let any other session acquire
+// the key until the lock-delay of the session has elapsed, and Campaign
+// waits out the lock-delay before acquiring the key.
+//
+// The returned channel is closed once leadership is lost, for example
+// because the session was invalidated or the leader record was deleted, or
+// when leadership is given up by Resign or Close. A client can only run one
+// campaign at a time.
+func (c *Client) Campaign(ctx context.Context, id, apiAddr, addr string) (lostCh <-chan struct{}, err error) {
+	start := c.clock.Now()
+	defer func() {
+		err = c.wrapError("campaign", err)
+		c.recordOp("campaign", start, 0, err)
+	}()
+
+	b, err := json.Marshal(node{
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.election != nil {
+		c.mu.Unlock()
+		return nil, disco.ErrCampaigning
+	}
+	e := &election{
+		ttl:    c.electionTTL,
+		lostCh: make(chan struct{}),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	c.election = e
+	lockDelay := c.electionLockDelay
+	c.mu.Unlock()
+
+	// Resign and Close stop the campaign, as well as ctx.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(e.ctx, cancel)
+	defer stop()
+
+	sessionID, _, err := c.session.Create(&api.SessionEntry{
+		Name:      c.leaderKey,
+		TTL:       e.ttl.String(),
+		Behavior:  api.SessionBehaviorDelete,
+		LockDelay: lockDelay,
+	}, (&api.WriteOptions{}).WithContext(ctx))
+	if err != nil {
+		c.endElection(e, false)
+		return nil, contextError(ctx, err)
+	}
+	c.mu.Lock()
+	e.sessionID = sessionID
+	c.mu.Unlock()
+	go c.session.RenewPeriodic(e.ttl.String(), sessionID, (&api.WriteOptions{}).WithContext(e.ctx), nil)
+
+	if err := c.acquire(ctx, sessionID, b); err != nil {
+		c.endElection(e, true)
+		return nil, err
+	}
+	c.sawLeader(disco.Leader{ID: id, APIAddr: apiAddr, Addr: addr})
+	go c.monitorElection(e)
+	return e.lostCh, nil
+}
+
+// Resign gives up leadership acquired by Campaign, deleting the leader
+// record if it is still held by this client, and destroying the session.
+// Unlike invalidation of the session, this does not trigger the lock-delay,
+// so another node may acquire leadership immediately. If a campaign is in
+// progress, it is stopped. It is not an error to call Resign if the client
+// is not campaigning.
+func (c *Client) Resign(ctx context.Context) (err error) {
+	start := c.clock.Now()
+	defer func() {
+		err = c.wrapError("resign", err)
+		c.recordOp("resign", start, 0, err)
+	}()
+
+	c.mu.Lock()
+	e := c.election
+	c.election = nil
+	var sessionID string
+	if e != nil {
+		sessionID = e.sessionID
+	}
+	c.mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	e.stop()
+	if sessionID == "" {
+		// The session has not been created yet, and the campaign cleans
+		// up after itself once it sees it has been stopped.
+		return nil
+	}
+
+	// The record is deleted, rather than released, so that the leader
+	// record is not left in place without a holder.
+	ops := api.KVTxnOps{
+		&api.KVTxnOp{Verb: api.KVCheckSession, Key: c.leaderKey, Session: sessionID},
+		&api.KVTxnOp{Verb: api.KVDelete, Key: c.leaderKey},
+	}
+	_, resp, _, err := c.client.Txn(ops, (&api.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	for _, txnErr := range resp.Errors {
+		// A failed check means leadership was already lost.
+		if txnErr.OpIndex != 0 {
+			return fmt.Errorf("%w: failed to delete %s: %s", disco.ErrConflict, c.leaderKey, txnErr.What)
+		}
+	}
+	_, err = c.session.Destroy(sessionID, (&api.WriteOptions{}).WithContext(ctx))
+	return err
+}
+
+// acquire acquires the leader key for session sessionID, with value b,
+// blocking until it is acquired or ctx is done.
+func (c *Client) acquire(ctx context.Context, sessionID string, b []byte) error {
+	var index uint64
+	var unheld bool
+	for {
+		p := &api.KVPair{Key: c.leaderKey, Value: b, Session: sessionID}
+		ok, _, err := c.client.Acquire(p, (&api.WriteOptions{}).WithContext(ctx))
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if ok {
+			return nil
+		}
+
+		opts := &api.QueryOptions{WaitIndex: index}
+		pair, meta, err := c.client.Get(c.leaderKey, opts.WithContext(ctx))
+		if err != nil {
+			return contextError(ctx, err)
+		}
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		if pair == nil || pair.Session == "" {
+			// The key is not held. If it was not held when the acquire
+			// was refused either, the refusal must be because of the
+			// lock-delay, which is not reflected in the index, so poll
+			// until it has elapsed.
+			if unheld && !sleepContext(ctx, watchRetryInterval) {
+				return ctx.Err()
+			}
+			unheld = true
+			index = 0
+			continue
+		}
+		unheld = false
+	}
+}
+
+// monitorElection uses blocking queries on the leader key to detect when
+// leadership held by e is lost. Leadership is also considered lost if the
+// key cannot be read for longer than the session TTL, since the session may
+// have expired in the meantime.
+func (c *Client) monitorElection(e *election) {
+	var index uint64
+	lastRead := time.Now()
+	for {
+		opts := &api.QueryOptions{WaitIndex: index}
+		pair, meta, err := c.client.Get(c.leaderKey, opts.WithContext(e.ctx))
+		if err != nil {
+			if e.ctx.Err() != nil {
+				return
+			}
+			if time.Since(lastRead) > e.ttl {
+				c.endElection(e, true)
+				return
+			}
+			if !sleepContext(e.ctx, watchRetryInterval) {
+				return
+			}
+			continue
+		}
+		lastRead = time.Now()
+
+		if pair == nil || pair.Session != e.sessionID {
+			c.endElection(e, true)
+			return
+		}
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+	}
+}
+
+// endElection stops election e, destroying its session if destroy is true.
+func (c *Client) endElection(e *election, destroy bool) {
+	c.mu.Lock()
+	if c.election == e {
+		c.election = nil
+	}
+	c.mu.Unlock()
+	e.stop()
+	if destroy {
+		c.session.Destroy(e.sessionID, nil)
+	}
+}
+
+// contextError returns the error of ctx if it is done, err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
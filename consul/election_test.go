@@ -0,0 +1,160 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+func Test_Campaign(t *testing.T) {
+	key := randomString()
+	c1, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c1.Close()
+	c2, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c2.Close()
+
+	lost1, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	mustGetLeader(t, c1, "1")
+	if _, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002"); !errors.Is(err, disco.ErrCampaigning) {
+		t.Fatalf("wrong error for second campaign, exp %v, got %v", disco.ErrCampaigning, err)
+	}
+
+	// The second campaign must block until the first leader resigns.
+	errCh := make(chan error, 1)
+	go func() {
+		_, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004")
+		errCh <- err
+	}()
+	select {
+	case err := <-errCh:
+		t.Fatalf("campaign returned while leadership held: %v", err)
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	if err := c1.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	mustBeLost(t, lost1)
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("failed to campaign: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for campaign after resignation")
+	}
+	mustGetLeader(t, c2, "2")
+
+	if err := c2.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	if err := c2.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign when not campaigning: %s", err.Error())
+	}
+	_, _, _, ok, err := c2.GetLeader()
+	if err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("leader found after resignation")
+	}
+}
+
+func Test_CampaignSessionInvalidated(t *testing.T) {
+	key := randomString()
+	c1, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c1.Close()
+	c1.SetElectionTiming(10*time.Second, 2*time.Second)
+	c2, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c2.Close()
+
+	lost1, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	pair, _, err := c1.client.Get(c1.leaderKey, nil)
+	if err != nil {
+		t.Fatalf("failed to get leader key: %s", err.Error())
+	}
+	if pair.Session == "" {
+		t.Fatalf("leader key not held by a session")
+	}
+
+	// Destroying the session simulates it expiring.
+	if _, err := c1.session.Destroy(pair.Session, nil); err != nil {
+		t.Fatalf("failed to destroy session: %s", err.Error())
+	}
+	mustBeLost(t, lost1)
+
+	// The lock-delay of the invalidated session must be waited out.
+	start := time.Now()
+	if _, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	if time.Since(start) < time.Second {
+		t.Fatalf("leadership acquired during lock-delay")
+	}
+	mustGetLeader(t, c2, "2")
+}
+
+func Test_CampaignContextCanceled(t *testing.T) {
+	key := randomString()
+	c1, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c1.Close()
+	c2, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c2.Close()
+
+	if _, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err = c2.Campaign(ctx, "2", "http://localhost:4003", "localhost:4004")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error for canceled campaign, exp %v, got %v", context.DeadlineExceeded, err)
+	}
+	mustGetLeader(t, c1, "1")
+
+	// A canceled campaign must not prevent another one.
+	if err := c1.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	if _, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	mustGetLeader(t, c2, "2")
+}
+
+func mustBeLost(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for leadership loss")
+	}
+}
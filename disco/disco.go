@@ -0,0 +1,221 @@
+// Package disco defines the interfaces implemented by the node-discovery
+// clients in this module, allowing callers to work with any backend
+// without depending on a concrete client type.
+package disco
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrLeaseLost is sent when the lease or session to which a leader
+	// record is bound expires, or can no longer be renewed.
+	ErrLeaseLost = errors.New("leader lease lost")
+
+	// ErrCampaigning is returned by Campaign if the client is already
+	// campaigning for, or holding, leadership.
+	ErrCampaigning = errors.New("already campaigning")
+)
+
+// LeaderStore is the interface implemented by clients which record the
+// leader of an rqlite cluster in a key-value store, such as Consul or etcd.
+type LeaderStore interface {
+	// GetLeader returns the leader as recorded in the store. If a leader
+	// exists, ok will be set to true, false otherwise.
+	GetLeader() (id string, apiAddr string, addr string, ok bool, e error)
+
+	// GetLeaderContext is like GetLeader, but honors the deadline and
+	// cancellation of ctx.
+	GetLeaderContext(ctx context.Context) (id string, apiAddr string, addr string, ok bool, e error)
+
+	// GetLeaderRecord returns the leader as recorded in the store, along
+	// with the metadata of the record. If a leader exists, ok will be set
+	// to true, false otherwise.
+	GetLeaderRecord() (rec LeaderRecord, ok bool, e error)
+
+	// GetLeaderRecordContext is like GetLeaderRecord, but honors the
+	// deadline and cancellation of ctx.
+	GetLeaderRecordContext(ctx context.Context) (rec LeaderRecord, ok bool, e error)
+
+	// InitializeLeader sets the leader to the given details, but only if
+	// no leader has already been set. If initialization succeeds, ok is
+	// set to true.
+	InitializeLeader(id, apiAddr, addr string) (ok bool, e error)
+
+	// InitializeLeaderContext is like InitializeLeader, but honors the
+	// deadline and cancellation of ctx.
+	InitializeLeaderContext(ctx context.Context, id, apiAddr, addr string) (ok bool, e error)
+
+	// SetLeader unconditionally sets the leader to the given details.
+	SetLeader(id, apiAddr, addr string) error
+
+	// SetLeaderContext is like SetLeader, but honors the deadline and
+	// cancellation of ctx.
+	SetLeaderContext(ctx context.Context, id, apiAddr, addr string) error
+
+	// UpdateLeaderIf sets the leader to leader, but only if the recorded
+	// leader still matches expected. A zero expected matches only when no
+	// leader is recorded. ok is false if the record did not match.
+	UpdateLeaderIf(expected, leader Leader) (ok bool, e error)
+
+	// UpdateLeaderIfContext is like UpdateLeaderIf, but honors the deadline
+	// and cancellation of ctx.
+	UpdateLeaderIfContext(ctx context.Context, expected, leader Leader) (ok bool, e error)
+
+	// ResignLeader deletes the leader record, but only if it is still
+	// owned by the node with the given id. ok is true if the record was
+	// deleted.
+	ResignLeader(id string) (ok bool, e error)
+
+	// ResignLeaderContext is like ResignLeader, but honors the deadline
+	// and cancellation of ctx.
+	ResignLeaderContext(ctx context.Context, id string) (ok bool, e error)
+
+	// DeleteLeader unconditionally deletes the leader record. It is
+	// intended for administrative recovery.
+	DeleteLeader() error
+
+	// DeleteLeaderContext is like DeleteLeader, but honors the deadline
+	// and cancellation of ctx.
+	DeleteLeaderContext(ctx context.Context) error
+
+	// String returns a name identifying the backend.
+	String() string
+
+	// Close closes the client.
+	Close() error
+}
+
+// Node holds the details of an rqlite node.
+type Node struct {
+	ID      string
+	APIAddr string
+	Addr    string
+}
+
+// Leader holds the details of the node which leads a cluster.
+type Leader Node
+
+// LeaderRecord is a leader record, along with its metadata.
+type LeaderRecord struct {
+	Leader
+
+	// CreateRevision is the store revision at which the record was
+	// created. It is the CreateIndex in Consul, and the CreateRevision in
+	// etcd.
+	CreateRevision uint64
+
+	// ModRevision is the store revision at which the record was last
+	// written. It is the ModifyIndex in Consul, and the ModRevision in etcd.
+	ModRevision uint64
+
+	// Version is the number of times the record has been written since it
+	// was created. It is only reported by etcd, and is zero for Consul.
+	Version uint64
+
+	// Term increases every time the record is written, including when it
+	// is deleted and created again, so it can be used as a fencing token:
+	// of two records, the one with the greater Term was written later.
+	Term uint64
+
+	// UpdatedAt is the time the record was written, according to the
+	// clock of the writer. It is zero for records written by clients which
+	// did not record it.
+	UpdatedAt time.Time
+}
+
+// NodeRegistry is the interface implemented by stores which record the
+// membership of a cluster, allowing joining nodes to discover every peer.
+type NodeRegistry interface {
+	// RegisterNode records the node with the given details as a member of
+	// the cluster, replacing any existing record for id.
+	RegisterNode(id, apiAddr, addr string) error
+
+	// RegisterNodeContext is like RegisterNode, but honors the deadline
+	// and cancellation of ctx.
+	RegisterNodeContext(ctx context.Context, id, apiAddr, addr string) error
+
+	// DeregisterNode removes the record of the node with the given id. It
+	// is not an error if no such record exists.
+	DeregisterNode(id string) error
+
+	// DeregisterNodeContext is like DeregisterNode, but honors the deadline
+	// and cancellation of ctx.
+	DeregisterNodeContext(ctx context.Context, id string) error
+
+	// ListNodes returns every registered node, sorted by ID.
+	ListNodes() ([]Node, error)
+
+	// ListNodesContext is like ListNodes, but honors the deadline and
+	// cancellation of ctx.
+	ListNodesContext(ctx context.Context) ([]Node, error)
+}
+
+// Lookuper is the interface implemented by clients which resolve the
+// addresses of rqlite nodes, such as those using DNS and DNS SRV records.
+type Lookuper interface {
+	// Lookup returns the network addresses of the nodes.
+	Lookup() ([]string, error)
+
+	// LookupContext is like Lookup, but honors the deadline and
+	// cancellation of ctx.
+	LookupContext(ctx context.Context) ([]string, error)
+
+	// Stats returns diagnostics information about the client.
+	Stats() (map[string]interface{}, error)
+}
+
+// LeaderEvent describes a change to the leader record held in a store.
+type LeaderEvent struct {
+	// ID, APIAddr and Addr are the details of the new leader. They are
+	// empty if Deleted is set.
+	ID      string
+	APIAddr string
+	Addr    string
+
+	// Deleted is set if the leader record was removed from the store.
+	Deleted bool
+
+	// Err is set if the watch encountered an error. The watch continues
+	// after reporting an error unless its context is done.
+	Err error
+}
+
+// LeaderWatcher is the interface implemented by stores which can notify
+// callers of changes to the leader record.
+type LeaderWatcher interface {
+	// WatchLeader returns a channel on which changes to the leader record
+	// are sent. If a leader is recorded when the watch starts, it is sent
+	// as the first event. The channel is closed once ctx is done.
+	WatchLeader(ctx context.Context) <-chan LeaderEvent
+}
+
+// Elector is the interface implemented by stores which can elect a leader
+// using the native primitives of the store, such as Consul sessions or etcd
+// elections.
+type Elector interface {
+	// Campaign blocks until the caller holds leadership, with the given
+	// details recorded as the leader, or ctx is done. The returned channel
+	// is closed once leadership is lost or given up.
+	Campaign(ctx context.Context, id, apiAddr, addr string) (<-chan struct{}, error)
+
+	// Resign gives up leadership acquired by Campaign, stopping any
+	// campaign in progress.
+	Resign(ctx context.Context) error
+}
+
+// Clock is the source of the current time for a client. It is used for the
+// timestamps a client writes and reports, and can be replaced in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the Clock returning the system time. It is used by the
+// clients unless another Clock is set.
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
@@ -0,0 +1,716 @@
+// Package disctest provides conformance test suites for implementations
+// of the disco interfaces. Every backend should pass the suites for the
+// interfaces it implements, ensuring they all honor the same behavioral
+// contract.
+package disctest
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+// Factory returns a new LeaderStore which records the leader under the
+// given key. Stores returned for the same key must share the same
+// underlying state, and stores returned for different keys must not.
+type Factory func(t *testing.T, key string) disco.LeaderStore
+
+// RunLeaderStoreSuite runs the conformance test suite against the
+// LeaderStore implementation returned by factory.
+func RunLeaderStoreSuite(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, factory Factory)
+	}{
+		{"String", testString},
+		{"GetLeaderMissing", testGetLeaderMissing},
+		{"InitializeLeader", testInitializeLeader},
+		{"GetLeaderRecord", testGetLeaderRecord},
+		{"InitializeLeaderConflict", testInitializeLeaderConflict},
+		{"InitializeLeaderTwice", testInitializeLeaderTwice},
+		{"SetLeaderOverwrite", testSetLeaderOverwrite},
+		{"UpdateLeaderIf", testUpdateLeaderIf},
+		{"UpdateLeaderIfMissing", testUpdateLeaderIfMissing},
+		{"UpdateLeaderIfRace", testUpdateLeaderIfRace},
+		{"ResignLeader", testResignLeader},
+		{"DeleteLeader", testDeleteLeader},
+		{"TermAfterDelete", testTermAfterDelete},
+		{"KeysIsolated", testKeysIsolated},
+		{"CASRace", testCASRace},
+		{"ConcurrentInitializers", testConcurrentInitializers},
+		{"ContextCanceled", testContextCanceled},
+		{"Close", testClose},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory)
+		})
+	}
+}
+
+// RunNodeRegistrySuite runs the conformance test suite against the
+// disco.NodeRegistry implementation returned by factory. The stores
+// returned by factory must implement disco.NodeRegistry.
+func RunNodeRegistrySuite(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, factory Factory)
+	}{
+		{"ListNodesEmpty", testListNodesEmpty},
+		{"RegisterNode", testRegisterNode},
+		{"RegisterNodeReplace", testRegisterNodeReplace},
+		{"DeregisterNode", testDeregisterNode},
+		{"NodesIsolated", testNodesIsolated},
+		{"NodesSeparateFromLeader", testNodesSeparateFromLeader},
+		{"ConcurrentRegistration", testConcurrentRegistration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, factory)
+		})
+	}
+}
+
+func testString(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+	if s.String() == "" {
+		t.Fatalf("store returned empty name")
+	}
+}
+
+func testGetLeaderMissing(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+	mustNotHaveLeader(t, s)
+}
+
+func testInitializeLeader(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+	mustNotHaveLeader(t, s)
+
+	ok, err := s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to initialize leader")
+	}
+	mustHaveLeader(t, s, "1", "http://localhost:4001", "localhost:4002")
+}
+
+func testGetLeaderRecord(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	_, ok, err := s.GetLeaderRecord()
+	if err != nil {
+		t.Fatalf("failed to GetLeaderRecord: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("leader record found when not expected")
+	}
+
+	ok, err = s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil || !ok {
+		t.Fatalf("failed to initialize leader, ok: %t, err: %v", ok, err)
+	}
+	rec1 := mustGetLeaderRecord(t, s)
+	if exp := (disco.Leader{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}); rec1.Leader != exp {
+		t.Fatalf("retrieved incorrect details for leader, exp %+v, got %+v", exp, rec1.Leader)
+	}
+	if rec1.Term == 0 || rec1.CreateRevision == 0 || rec1.ModRevision < rec1.CreateRevision {
+		t.Fatalf("invalid revisions for new record: %+v", rec1)
+	}
+	if d := time.Since(rec1.UpdatedAt); d < -time.Minute || d > time.Minute {
+		t.Fatalf("invalid update time for new record: %s", rec1.UpdatedAt)
+	}
+
+	if err := s.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	rec2 := mustGetLeaderRecord(t, s)
+	if rec2.ID != "2" {
+		t.Fatalf("retrieved incorrect leader, exp 2, got %s", rec2.ID)
+	}
+	if rec2.Term <= rec1.Term {
+		t.Fatalf("term did not increase, was %d, now %d", rec1.Term, rec2.Term)
+	}
+	if rec2.ModRevision <= rec1.ModRevision {
+		t.Fatalf("mod revision did not increase, was %d, now %d", rec1.ModRevision, rec2.ModRevision)
+	}
+	if rec2.CreateRevision != rec1.CreateRevision {
+		t.Fatalf("create revision changed, was %d, now %d", rec1.CreateRevision, rec2.CreateRevision)
+	}
+	if rec2.UpdatedAt.Before(rec1.UpdatedAt) {
+		t.Fatalf("update time went backwards, was %s, now %s", rec1.UpdatedAt, rec2.UpdatedAt)
+	}
+}
+
+func testInitializeLeaderConflict(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	if err := s.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	ok, err := s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("initialized leader when should have failed")
+	}
+	mustHaveLeader(t, s, "2", "http://localhost:4003", "localhost:4004")
+}
+
+func testInitializeLeaderTwice(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	ok, err := s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil || !ok {
+		t.Fatalf("failed to initialize leader, ok: %t, err: %v", ok, err)
+	}
+	ok, err = s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("initialized leader a second time with identical details")
+	}
+	mustHaveLeader(t, s, "1", "http://localhost:4001", "localhost:4002")
+}
+
+func testSetLeaderOverwrite(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	ok, err := s.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil || !ok {
+		t.Fatalf("failed to initialize leader, ok: %t, err: %v", ok, err)
+	}
+	if err := s.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustHaveLeader(t, s, "2", "http://localhost:4003", "localhost:4004")
+
+	if err := s.SetLeader("3", "http://localhost:4005", "localhost:4006"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustHaveLeader(t, s, "3", "http://localhost:4005", "localhost:4006")
+}
+
+func testUpdateLeaderIf(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	l1 := disco.Leader{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}
+	l2 := disco.Leader{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	l3 := disco.Leader{ID: "3", APIAddr: "http://localhost:4005", Addr: "localhost:4006"}
+	if err := s.SetLeader(l1.ID, l1.APIAddr, l1.Addr); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+
+	ok, err := s.UpdateLeaderIf(l1, l2)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to update leader when record matched")
+	}
+	mustHaveLeader(t, s, l2.ID, l2.APIAddr, l2.Addr)
+
+	// The record no longer matches l1, so this update must conflict.
+	ok, err = s.UpdateLeaderIf(l1, l3)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("updated leader when record did not match")
+	}
+	mustHaveLeader(t, s, l2.ID, l2.APIAddr, l2.Addr)
+
+	// A zero expected record must not match an existing leader.
+	ok, err = s.UpdateLeaderIf(disco.Leader{}, l3)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("updated leader when expecting no leader")
+	}
+	mustHaveLeader(t, s, l2.ID, l2.APIAddr, l2.Addr)
+}
+
+func testUpdateLeaderIfMissing(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	l1 := disco.Leader{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}
+	ok, err := s.UpdateLeaderIf(l1, l1)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("updated leader when no leader was recorded")
+	}
+	mustNotHaveLeader(t, s)
+
+	ok, err = s.UpdateLeaderIf(disco.Leader{}, l1)
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to update leader when no leader was recorded")
+	}
+	mustHaveLeader(t, s, l1.ID, l1.APIAddr, l1.Addr)
+}
+
+func testUpdateLeaderIfRace(t *testing.T, factory Factory) {
+	key := randomKey()
+	l0 := disco.Leader{ID: "leader", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}
+	const n = 5
+	stores := make([]disco.LeaderStore, n)
+	for i := range stores {
+		stores[i] = factory(t, key)
+		defer stores[i].Close()
+	}
+	if err := stores[0].SetLeader(l0.ID, l0.APIAddr, l0.Addr); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+
+	results := make([]bool, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			id, apiAddr, addr := nodeDetails(i)
+			ok, err := stores[i].UpdateLeaderIf(l0, disco.Leader{ID: id, APIAddr: apiAddr, Addr: addr})
+			if err != nil {
+				t.Errorf("error when updating leader: %s", err.Error())
+				return
+			}
+			results[i] = ok
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	mustHaveSingleWinner(t, stores[0], results)
+}
+
+func testResignLeader(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	ok, err := s.ResignLeader("1")
+	if err != nil {
+		t.Fatalf("error when resigning leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("resigned leader when no leader was recorded")
+	}
+
+	if err := s.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	ok, err = s.ResignLeader("2")
+	if err != nil {
+		t.Fatalf("error when resigning leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("resigned leader owned by another node")
+	}
+	mustHaveLeader(t, s, "1", "http://localhost:4001", "localhost:4002")
+
+	ok, err = s.ResignLeader("1")
+	if err != nil {
+		t.Fatalf("error when resigning leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to resign leader")
+	}
+	mustNotHaveLeader(t, s)
+
+	// Leadership can be claimed again once resigned.
+	ok, err = s.InitializeLeader("2", "http://localhost:4003", "localhost:4004")
+	if err != nil || !ok {
+		t.Fatalf("failed to initialize leader after resignation, ok: %t, err: %v", ok, err)
+	}
+	mustHaveLeader(t, s, "2", "http://localhost:4003", "localhost:4004")
+}
+
+func testDeleteLeader(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	if err := s.DeleteLeader(); err != nil {
+		t.Fatalf("error when deleting missing leader: %s", err.Error())
+	}
+	if err := s.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	if err := s.DeleteLeader(); err != nil {
+		t.Fatalf("error when deleting leader: %s", err.Error())
+	}
+	mustNotHaveLeader(t, s)
+}
+
+func testTermAfterDelete(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	if err := s.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	rec1 := mustGetLeaderRecord(t, s)
+	if err := s.DeleteLeader(); err != nil {
+		t.Fatalf("error when deleting leader: %s", err.Error())
+	}
+	if err := s.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	rec2 := mustGetLeaderRecord(t, s)
+	if rec2.Term <= rec1.Term {
+		t.Fatalf("term did not increase across deletion, was %d, now %d", rec1.Term, rec2.Term)
+	}
+}
+
+func testKeysIsolated(t *testing.T, factory Factory) {
+	s1 := factory(t, randomKey())
+	defer s1.Close()
+	s2 := factory(t, randomKey())
+	defer s2.Close()
+
+	if err := s1.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustNotHaveLeader(t, s2)
+}
+
+func testCASRace(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	const n = 10
+	results := make([]bool, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ok, err := s.InitializeLeader(nodeDetails(i))
+			if err != nil {
+				t.Errorf("error when initializing leader: %s", err.Error())
+				return
+			}
+			results[i] = ok
+		}(i)
+	}
+	wg.Wait()
+	mustHaveSingleWinner(t, s, results)
+}
+
+func testConcurrentInitializers(t *testing.T, factory Factory) {
+	key := randomKey()
+	const n = 5
+	stores := make([]disco.LeaderStore, n)
+	for i := range stores {
+		stores[i] = factory(t, key)
+		defer stores[i].Close()
+	}
+
+	results := make([]bool, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			ok, err := stores[i].InitializeLeader(nodeDetails(i))
+			if err != nil {
+				t.Errorf("error when initializing leader: %s", err.Error())
+				return
+			}
+			results[i] = ok
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	for i := range stores {
+		mustHaveSingleWinner(t, stores[i], results)
+	}
+}
+
+func testContextCanceled(t *testing.T, factory Factory) {
+	s := factory(t, randomKey())
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, _, _, err := s.GetLeaderContext(ctx); err == nil {
+		t.Fatalf("GetLeaderContext succeeded with canceled context")
+	}
+	if _, _, err := s.GetLeaderRecordContext(ctx); err == nil {
+		t.Fatalf("GetLeaderRecordContext succeeded with canceled context")
+	}
+	if _, err := s.InitializeLeaderContext(ctx, "1", "http://localhost:4001", "localhost:4002"); err == nil {
+		t.Fatalf("InitializeLeaderContext succeeded with canceled context")
+	}
+	if err := s.SetLeaderContext(ctx, "1", "http://localhost:4001", "localhost:4002"); err == nil {
+		t.Fatalf("SetLeaderContext succeeded with canceled context")
+	}
+	if _, err := s.UpdateLeaderIfContext(ctx, disco.Leader{}, disco.Leader{ID: "1"}); err == nil {
+		t.Fatalf("UpdateLeaderIfContext succeeded with canceled context")
+	}
+	if _, err := s.ResignLeaderContext(ctx, "1"); err == nil {
+		t.Fatalf("ResignLeaderContext succeeded with canceled context")
+	}
+	if err := s.DeleteLeaderContext(ctx); err == nil {
+		t.Fatalf("DeleteLeaderContext succeeded with canceled context")
+	}
+	mustNotHaveLeader(t, s)
+}
+
+func testClose(t *testing.T, factory Factory) {
+	key := randomKey()
+	s := factory(t, key)
+	if err := s.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close store: %s", err.Error())
+	}
+
+	// Closing a client must not remove the leader record.
+	s = factory(t, key)
+	defer s.Close()
+	mustHaveLeader(t, s, "1", "http://localhost:4001", "localhost:4002")
+}
+
+func testListNodesEmpty(t *testing.T, factory Factory) {
+	r := mustNodeRegistry(t, factory, randomKey())
+	defer r.Close()
+	mustHaveNodes(t, r)
+}
+
+func testRegisterNode(t *testing.T, factory Factory) {
+	r := mustNodeRegistry(t, factory, randomKey())
+	defer r.Close()
+
+	n1 := disco.Node{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"}
+	n2 := disco.Node{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	if err := r.RegisterNode(n2.ID, n2.APIAddr, n2.Addr); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	if err := r.RegisterNode(n1.ID, n1.APIAddr, n1.Addr); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	mustHaveNodes(t, r, n1, n2)
+}
+
+func testRegisterNodeReplace(t *testing.T, factory Factory) {
+	r := mustNodeRegistry(t, factory, randomKey())
+	defer r.Close()
+
+	if err := r.RegisterNode("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	n1 := disco.Node{ID: "1", APIAddr: "http://localhost:5001", Addr: "localhost:5002"}
+	if err := r.RegisterNode(n1.ID, n1.APIAddr, n1.Addr); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	mustHaveNodes(t, r, n1)
+}
+
+func testDeregisterNode(t *testing.T, factory Factory) {
+	r := mustNodeRegistry(t, factory, randomKey())
+	defer r.Close()
+
+	if err := r.DeregisterNode("1"); err != nil {
+		t.Fatalf("error when deregistering missing node: %s", err.Error())
+	}
+	n2 := disco.Node{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	if err := r.RegisterNode("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	if err := r.RegisterNode(n2.ID, n2.APIAddr, n2.Addr); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	if err := r.DeregisterNode("1"); err != nil {
+		t.Fatalf("error when deregistering node: %s", err.Error())
+	}
+	mustHaveNodes(t, r, n2)
+}
+
+func testNodesIsolated(t *testing.T, factory Factory) {
+	r1 := mustNodeRegistry(t, factory, randomKey())
+	defer r1.Close()
+	r2 := mustNodeRegistry(t, factory, randomKey())
+	defer r2.Close()
+
+	if err := r1.RegisterNode("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	mustHaveNodes(t, r2)
+}
+
+func testNodesSeparateFromLeader(t *testing.T, factory Factory) {
+	r := mustNodeRegistry(t, factory, randomKey())
+	defer r.Close()
+
+	if err := r.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	mustHaveNodes(t, r)
+
+	n2 := disco.Node{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	if err := r.RegisterNode(n2.ID, n2.APIAddr, n2.Addr); err != nil {
+		t.Fatalf("error when registering node: %s", err.Error())
+	}
+	if err := r.DeregisterNode("1"); err != nil {
+		t.Fatalf("error when deregistering node: %s", err.Error())
+	}
+	mustHaveLeader(t, r, "1", "http://localhost:4001", "localhost:4002")
+	mustHaveNodes(t, r, n2)
+}
+
+func testConcurrentRegistration(t *testing.T, factory Factory) {
+	key := randomKey()
+	const n = 5
+	exp := make([]disco.Node, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		r := mustNodeRegistry(t, factory, key)
+		defer r.Close()
+		id, apiAddr, addr := nodeDetails(i)
+		exp[i] = disco.Node{ID: id, APIAddr: apiAddr, Addr: addr}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := r.RegisterNode(id, apiAddr, addr); err != nil {
+				t.Errorf("error when registering node: %s", err.Error())
+			}
+		}()
+	}
+	wg.Wait()
+
+	r := mustNodeRegistry(t, factory, key)
+	defer r.Close()
+	mustHaveNodes(t, r, exp...)
+}
+
+// nodeRegistryStore is a LeaderStore which is also a NodeRegistry.
+type nodeRegistryStore interface {
+	disco.LeaderStore
+	disco.NodeRegistry
+}
+
+func mustNodeRegistry(t *testing.T, factory Factory, key string) nodeRegistryStore {
+	t.Helper()
+	s := factory(t, key)
+	r, ok := s.(nodeRegistryStore)
+	if !ok {
+		s.Close()
+		t.Fatalf("%s does not implement disco.NodeRegistry", s)
+	}
+	return r
+}
+
+func mustHaveNodes(t *testing.T, r disco.NodeRegistry, exp ...disco.Node) {
+	t.Helper()
+	nodes, err := r.ListNodes()
+	if err != nil {
+		t.Fatalf("failed to ListNodes: %s", err.Error())
+	}
+	if len(nodes) != len(exp) {
+		t.Fatalf("wrong number of nodes, exp %d, got %d: %+v", len(exp), len(nodes), nodes)
+	}
+	for i := range exp {
+		if nodes[i] != exp[i] {
+			t.Fatalf("wrong node at index %d, exp %+v, got %+v", i, exp[i], nodes[i])
+		}
+	}
+}
+
+func mustNotHaveLeader(t *testing.T, s disco.LeaderStore) {
+	t.Helper()
+	_, _, _, ok, err := s.GetLeader()
+	if err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("leader found when not expected")
+	}
+}
+
+func mustHaveLeader(t *testing.T, s disco.LeaderStore, expID, expAPIAddr, expAddr string) {
+	t.Helper()
+	id, apiAddr, addr, ok, err := s.GetLeader()
+	if err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("leader not found when expected")
+	}
+	if id != expID || apiAddr != expAPIAddr || addr != expAddr {
+		t.Fatalf("retrieved incorrect details for leader, exp (%s, %s, %s), got (%s, %s, %s)",
+			expID, expAPIAddr, expAddr, id, apiAddr, addr)
+	}
+}
+
+func mustGetLeaderRecord(t *testing.T, s disco.LeaderStore) disco.LeaderRecord {
+	t.Helper()
+	rec, ok, err := s.GetLeaderRecord()
+	if err != nil {
+		t.Fatalf("failed to GetLeaderRecord: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("leader record not found when expected")
+	}
+	return rec
+}
+
+func mustHaveSingleWinner(t *testing.T, s disco.LeaderStore, results []bool) {
+	t.Helper()
+	winner := -1
+	for i, ok := range results {
+		if !ok {
+			continue
+		}
+		if winner != -1 {
+			t.Fatalf("both initializer %d and %d succeeded", winner, i)
+		}
+		winner = i
+	}
+	if winner == -1 {
+		t.Fatalf("no initializer succeeded")
+	}
+	id, apiAddr, addr := nodeDetails(winner)
+	mustHaveLeader(t, s, id, apiAddr, addr)
+}
+
+func nodeDetails(i int) (id, apiAddr, addr string) {
+	return fmt.Sprintf("%d", i), fmt.Sprintf("http://localhost:%d", 5000+2*i),
+		fmt.Sprintf("localhost:%d", 5001+2*i)
+}
+
+func randomKey() string {
+	const chars = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	b := make([]byte, 10)
+	for i := range b {
+		b[i] = chars[rand.Intn(len(chars))]
+	}
+	return "disctest-" + string(b)
+}
@@ -0,0 +1,163 @@
+package disco
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Sentinel errors classifying the failures of the clients in this module.
+// Errors returned by the clients wrap one of them where the cause of the
+// failure is known, so they can be matched with errors.Is.
+var (
+	// ErrNotFound is returned when a name or key does not exist, for
+	// example because a DNS name does not resolve.
+	ErrNotFound = errors.New("not found")
+
+	// ErrUnavailable is returned when the backend cannot be reached, or is
+	// unable to serve requests, for example because it has no leader.
+	ErrUnavailable = errors.New("backend unavailable")
+
+	// ErrPermissionDenied is returned when the backend rejects the
+	// credentials of the client, or they do not allow the operation.
+	ErrPermissionDenied = errors.New("permission denied")
+
+	// ErrConflict is returned when a write is rejected because of a
+	// conflicting write or lock held by another client.
+	ErrConflict = errors.New("conflict")
+
+	// ErrCorruptRecord is returned when a record read from the backend
+	// cannot be decoded.
+	ErrCorruptRecord = errors.New("corrupt record")
+
+	// ErrNoAddresses is returned when a lookup succeeds, but yields no
+	// addresses, by DNS clients created with their WithNoAddressesError
+	// option. Otherwise such a lookup returns no addresses and no error.
+	ErrNoAddresses = errors.New("no addresses found")
+
+	// ErrInvalidConfig is returned when a configuration is not valid.
+	ErrInvalidConfig = errors.New("invalid config")
+)
+
+// Error is the error returned by a client when an operation fails. It
+// wraps both the error returned by the backend, which can be matched with
+// errors.As, and the sentinel error classifying it.
+type Error struct {
+	// Backend is the name of the backend, as returned by the String method
+	// of the client, or the mode of a Lookuper.
+	Backend string
+
+	// Op is the operation which failed, such as get_leader or lookup.
+	Op string
+
+	// Kind is the sentinel error classifying the failure, such as
+	// ErrUnavailable.
+	Kind error
+
+	// Err is the underlying error.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if errors.Is(e.Err, e.Kind) {
+		return fmt.Sprintf("%s %s: %s", e.Backend, e.Op, e.Err)
+	}
+	return fmt.Sprintf("%s %s: %s: %s", e.Backend, e.Op, e.Kind, e.Err)
+}
+
+// Unwrap returns the sentinel error classifying the failure, and the
+// underlying error.
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// WrapError returns err wrapped in an *Error for operation op of backend,
+// classified as kind. If err is nil, already an *Error, or kind is nil, err
+// is returned unchanged.
+func WrapError(backend, op string, kind, err error) error {
+	var discoErr *Error
+	if err == nil || kind == nil || errors.As(err, &discoErr) {
+		return err
+	}
+	return &Error{Backend: backend, Op: op, Kind: kind, Err: err}
+}
+
+// FieldError describes the problem with the value of one field of a
+// configuration.
+type FieldError struct {
+	// Field is the path of the field in the configuration file, made of
+	// the JSON names of the fields, such as "tls_config.cert_file" or
+	// "endpoints[0]".
+	Field string
+
+	// Problem describes what is wrong with the value of the field.
+	Problem string
+}
+
+// Error implements the error interface.
+func (e FieldError) Error() string {
+	return e.Field + ": " + e.Problem
+}
+
+// ConfigError is the error returned by the Validate methods of the
+// configurations of the clients. It lists every invalid field, and wraps
+// ErrInvalidConfig.
+type ConfigError struct {
+	Fields []FieldError
+}
+
+// Error implements the error interface.
+func (e *ConfigError) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, f := range e.Fields {
+		problems[i] = f.Error()
+	}
+	return ErrInvalidConfig.Error() + ": " + strings.Join(problems, "; ")
+}
+
+// Unwrap returns ErrInvalidConfig.
+func (e *ConfigError) Unwrap() error {
+	return ErrInvalidConfig
+}
+
+// Validator collects the problems found by the Validate method of a
+// configuration, so that they are all reported at once.
+type Validator struct {
+	fields []FieldError
+}
+
+// Add records a problem with the value of field, formatted as with
+// fmt.Sprintf.
+func (v *Validator) Add(field, format string, args ...interface{}) {
+	v.fields = append(v.fields, FieldError{Field: field, Problem: fmt.Sprintf(format, args...)})
+}
+
+// Nested records the problems in err, returned by the Validate method of
+// the value of field, with their paths prefixed by field.
+func (v *Validator) Nested(field string, err error) {
+	var cfgErr *ConfigError
+	if err == nil {
+		return
+	}
+	if !errors.As(err, &cfgErr) {
+		v.Add(field, "%s", err)
+		return
+	}
+	for _, f := range cfgErr.Fields {
+		if !strings.HasPrefix(f.Field, "[") {
+			f.Field = "." + f.Field
+		}
+		f.Field = field + f.Field
+		v.fields = append(v.fields, f)
+	}
+}
+
+// Err returns a *ConfigError listing the problems recorded, or nil if
+// there are none.
+func (v *Validator) Err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+	return &ConfigError{Fields: v.fields}
+}
@@ -0,0 +1,103 @@
+package disco
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func Test_WrapError(t *testing.T) {
+	err := WrapError("consul-kv", "get_leader", ErrUnavailable, io.EOF)
+	if !errors.Is(err, ErrUnavailable) {
+		t.Fatalf("error does not match kind: %s", err)
+	}
+	if !errors.Is(err, io.EOF) {
+		t.Fatalf("error does not match underlying error: %s", err)
+	}
+	if errors.Is(err, ErrNotFound) {
+		t.Fatalf("error matches wrong kind: %s", err)
+	}
+
+	var discoErr *Error
+	if !errors.As(err, &discoErr) {
+		t.Fatalf("error is not an *Error: %s", err)
+	}
+	if discoErr.Backend != "consul-kv" || discoErr.Op != "get_leader" {
+		t.Fatalf("wrong backend or op: %s %s", discoErr.Backend, discoErr.Op)
+	}
+	if exp, got := "consul-kv get_leader: backend unavailable: EOF", err.Error(); exp != got {
+		t.Fatalf("wrong error message, exp %q, got %q", exp, got)
+	}
+}
+
+func Test_WrapErrorKindInErr(t *testing.T) {
+	err := WrapError("dns", "lookup", ErrNoAddresses, fmt.Errorf("%w for rqlite", ErrNoAddresses))
+	if !errors.Is(err, ErrNoAddresses) {
+		t.Fatalf("error does not match kind: %s", err)
+	}
+	if exp, got := "dns lookup: no addresses found for rqlite", err.Error(); exp != got {
+		t.Fatalf("wrong error message, exp %q, got %q", exp, got)
+	}
+}
+
+func Test_WrapErrorUnchanged(t *testing.T) {
+	if err := WrapError("dns", "lookup", ErrNotFound, nil); err != nil {
+		t.Fatalf("nil error was wrapped: %s", err)
+	}
+	if err := WrapError("dns", "lookup", nil, io.EOF); err != io.EOF {
+		t.Fatalf("unclassified error was wrapped: %s", err)
+	}
+
+	inner := WrapError("etcd-kv", "get_leader", ErrUnavailable, io.EOF)
+	if err := WrapError("etcd-kv", "campaign", ErrUnavailable, inner); err != inner {
+		t.Fatalf("*Error was wrapped again: %s", err)
+	}
+}
+
+func Test_ConfigError(t *testing.T) {
+	err := error(&ConfigError{Fields: []FieldError{
+		{Field: "address", Problem: "should not contain HTTP or HTTPS"},
+		{Field: "retry.max_attempts", Problem: "must not be negative"},
+	}})
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("error does not match ErrInvalidConfig: %s", err)
+	}
+	exp := "invalid config: address: should not contain HTTP or HTTPS; retry.max_attempts: must not be negative"
+	if got := err.Error(); exp != got {
+		t.Fatalf("wrong error message, exp %q, got %q", exp, got)
+	}
+}
+
+func Test_Validator(t *testing.T) {
+	var v Validator
+	if err := v.Err(); err != nil {
+		t.Fatalf("empty validator returned error: %s", err)
+	}
+
+	var nested Validator
+	nested.Add("max_attempts", "must not be %s", "negative")
+	var list Validator
+	list.Add("[1]", "must not be empty")
+
+	v.Add("name", "must be set")
+	v.Nested("retry", nested.Err())
+	v.Nested("endpoints", list.Err())
+	v.Nested("tls_config", nil)
+	v.Nested("ca_file", errors.New("no such file"))
+
+	var cfgErr *ConfigError
+	if !errors.As(v.Err(), &cfgErr) {
+		t.Fatalf("expected *ConfigError, got %v", v.Err())
+	}
+	exp := []FieldError{
+		{Field: "name", Problem: "must be set"},
+		{Field: "retry.max_attempts", Problem: "must not be negative"},
+		{Field: "endpoints[1]", Problem: "must not be empty"},
+		{Field: "ca_file", Problem: "no such file"},
+	}
+	if !reflect.DeepEqual(exp, cfgErr.Fields) {
+		t.Fatalf("wrong fields, exp %v, got %v", exp, cfgErr.Fields)
+	}
+}
@@ -0,0 +1,217 @@
+package disco
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultKey is the key under which clients of key-value stores record the
+// leader and nodes of a cluster, unless another is set with WithKey.
+const DefaultKey = "rqlite"
+
+// Client is implemented by every client built by NewFromConfig. Clients of
+// key-value stores, such as Consul and etcd, implement LeaderStore, and
+// DNS-based clients implement Lookuper; NewLeaderStoreFromConfig and
+// NewLookuperFromConfig return them as such. Optional interfaces, such as
+// LeaderWatcher, NodeRegistry and Elector, are implemented by the backends
+// which support them.
+type Client interface {
+	// String returns the mode of the backend.
+	String() string
+}
+
+// Factory builds a client of a backend from its configuration, read from r
+// in the format of the NewConfigFromReader function of the backend. r may
+// be nil, in which case the default configuration is used.
+type Factory func(r io.Reader, o *Options) (Client, error)
+
+// Options are the settings shared by every backend, set by the Options
+// passed to NewFromConfig. The zero value of each field leaves the default
+// of the backend in place.
+type Options struct {
+	// Key is the key under which clients of key-value stores record the
+	// leader and nodes of a cluster. It is not used by DNS-based clients.
+	Key string
+
+	// Logger is the logger to which the client logs.
+	Logger *slog.Logger
+
+	// Timeout bounds each operation of the client, including its retries.
+	Timeout time.Duration
+
+	// Clock is the clock used for timestamps and statistics.
+	Clock Clock
+
+	// TracerProvider is the provider of the tracer with which the client
+	// creates spans.
+	TracerProvider trace.TracerProvider
+
+	// DefaultPort is the port of the addresses resolved by DNS clients,
+	// unless their configuration sets another.
+	DefaultPort int
+
+	// StrictEnv makes loading the configuration fail if it refers to
+	// environment variables which are not set, and have no default.
+	StrictEnv bool
+}
+
+// Option sets a field of the Options of a client built by NewFromConfig.
+type Option func(*Options)
+
+// WithKey sets the key under which the client records the leader and
+// nodes of a cluster, instead of DefaultKey.
+func WithKey(key string) Option {
+	return func(o *Options) {
+		o.Key = key
+	}
+}
+
+// WithLogger sets the logger to which the client logs.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *Options) {
+		o.Logger = logger
+	}
+}
+
+// WithTimeout bounds each operation of the client, including its retries,
+// by d.
+func WithTimeout(d time.Duration) Option {
+	return func(o *Options) {
+		o.Timeout = d
+	}
+}
+
+// WithClock sets the clock used by the client for timestamps and
+// statistics.
+func WithClock(clock Clock) Option {
+	return func(o *Options) {
+		o.Clock = clock
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer with which the client
+// creates spans.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *Options) {
+		o.TracerProvider = tp
+	}
+}
+
+// WithDefaultPort sets the port of the addresses resolved by DNS clients,
+// unless their configuration sets another.
+func WithDefaultPort(port int) Option {
+	return func(o *Options) {
+		o.DefaultPort = port
+	}
+}
+
+// WithStrictEnv makes NewFromConfig fail if the configuration refers to
+// environment variables which are not set, and have no default.
+func WithStrictEnv() Option {
+	return func(o *Options) {
+		o.StrictEnv = true
+	}
+}
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a backend available to NewFromConfig under mode. The
+// backends of this module register themselves when their package is
+// imported, under the modes "consul-kv", "etcd-kv", "dns", "dns-srv" and
+// "memkv".
+// Register panics if it is called twice with the same mode, or if f is nil.
+func Register(mode string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	if f == nil {
+		panic("disco: Register factory is nil")
+	}
+	if _, dup := factories[mode]; dup {
+		panic("disco: Register called twice for mode " + mode)
+	}
+	factories[mode] = f
+}
+
+// Modes returns the sorted modes of the registered backends.
+func Modes() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+	modes := make([]string, 0, len(factories))
+	for mode := range factories {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// NewFromConfig returns a client of the backend registered under mode,
+// configured by the data read from r and by opts. If r is nil, the default
+// configuration of the backend is used.
+//
+// As with the drivers of database/sql, the package of a backend must be
+// imported for its mode to be registered, for example:
+//
+//	import _ "github.com/rqlite/rqlite-disco-clients/consul"
+func NewFromConfig(mode string, r io.Reader, opts ...Option) (Client, error) {
+	factoriesMu.RLock()
+	f, ok := factories[mode]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("disco: unknown mode %q (forgotten import?)", mode)
+	}
+	o := &Options{Key: DefaultKey}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return f(r, o)
+}
+
+// NewLeaderStoreFromConfig is like NewFromConfig, but returns the client as
+// a LeaderStore. If the backend registered under mode does not implement
+// LeaderStore, such as a DNS-based backend, the client is closed and an
+// error wrapping ErrInvalidConfig is returned.
+func NewLeaderStoreFromConfig(mode string, r io.Reader, opts ...Option) (LeaderStore, error) {
+	c, err := NewFromConfig(mode, r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := c.(LeaderStore)
+	if !ok {
+		closeClient(c)
+		return nil, fmt.Errorf("%w: mode %q does not provide a LeaderStore", ErrInvalidConfig, mode)
+	}
+	return s, nil
+}
+
+// NewLookuperFromConfig is like NewFromConfig, but returns the client as a
+// Lookuper. If the backend registered under mode does not implement
+// Lookuper, such as a key-value store, the client is closed and an error
+// wrapping ErrInvalidConfig is returned.
+func NewLookuperFromConfig(mode string, r io.Reader, opts ...Option) (Lookuper, error) {
+	c, err := NewFromConfig(mode, r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := c.(Lookuper)
+	if !ok {
+		closeClient(c)
+		return nil, fmt.Errorf("%w: mode %q does not provide a Lookuper", ErrInvalidConfig, mode)
+	}
+	return l, nil
+}
+
+// closeClient closes c if it can be closed.
+func closeClient(c Client) {
+	if closer, ok := c.(io.Closer); ok {
+		closer.Close()
+	}
+}
@@ -0,0 +1,125 @@
+package disco
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testClient struct {
+	cfg  string
+	opts *Options
+}
+
+func (c *testClient) String() string { return "test" }
+
+func Test_NewFromConfig(t *testing.T) {
+	Register("test", func(r io.Reader, o *Options) (Client, error) {
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(b) == 0 {
+			return nil, errors.New("empty config")
+		}
+		return &testClient{cfg: string(b), opts: o}, nil
+	})
+
+	c, err := NewFromConfig("test", strings.NewReader("config"), WithTimeout(time.Second))
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	tc, ok := c.(*testClient)
+	if !ok {
+		t.Fatalf("wrong type of client: %T", c)
+	}
+	if tc.cfg != "config" {
+		t.Fatalf("wrong config, exp config, got %s", tc.cfg)
+	}
+	if exp := (Options{Key: DefaultKey, Timeout: time.Second}); !reflect.DeepEqual(*tc.opts, exp) {
+		t.Fatalf("wrong options, exp %+v, got %+v", exp, *tc.opts)
+	}
+
+	c, err = NewFromConfig("test", strings.NewReader("config"), WithKey("cluster1"))
+	if err != nil {
+		t.Fatalf("failed to create client: %s", err)
+	}
+	if exp, got := "cluster1", c.(*testClient).opts.Key; exp != got {
+		t.Fatalf("wrong key, exp %s, got %s", exp, got)
+	}
+
+	if _, err := NewFromConfig("test", strings.NewReader("")); err == nil || err.Error() != "empty config" {
+		t.Fatalf("expected error of factory, got %v", err)
+	}
+
+	found := false
+	for _, mode := range Modes() {
+		found = found || mode == "test"
+	}
+	if !found {
+		t.Fatalf("registered mode not in %v", Modes())
+	}
+}
+
+func Test_NewFromConfigUnknownMode(t *testing.T) {
+	_, err := NewFromConfig("zookeeper", nil)
+	if err == nil {
+		t.Fatalf("expected error for unknown mode")
+	}
+	if !strings.Contains(err.Error(), `"zookeeper"`) {
+		t.Fatalf("error does not name mode: %s", err)
+	}
+}
+
+func Test_RegisterTwice(t *testing.T) {
+	Register("test-twice", func(io.Reader, *Options) (Client, error) { return nil, nil })
+	defer func() {
+		if recover() == nil {
+			t.Fatalf("expected panic when registering mode twice")
+		}
+	}()
+	Register("test-twice", func(io.Reader, *Options) (Client, error) { return nil, nil })
+}
+
+type closingClient struct {
+	closed bool
+}
+
+func (c *closingClient) String() string { return "closing" }
+
+func (c *closingClient) Close() error {
+	c.closed = true
+	return nil
+}
+
+func Test_NewTypedFromConfig(t *testing.T) {
+	c := &closingClient{}
+	Register("test-typed", func(io.Reader, *Options) (Client, error) { return c, nil })
+
+	_, err := NewLeaderStoreFromConfig("test-typed", nil)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if !strings.Contains(err.Error(), "LeaderStore") {
+		t.Fatalf("error does not name the interface: %s", err)
+	}
+	if !c.closed {
+		t.Fatalf("client not closed")
+	}
+
+	c.closed = false
+	_, err = NewLookuperFromConfig("test-typed", nil)
+	if !errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+	if !c.closed {
+		t.Fatalf("client not closed")
+	}
+
+	if _, err := NewLookuperFromConfig("zookeeper", nil); err == nil || errors.Is(err, ErrInvalidConfig) {
+		t.Fatalf("expected error for unknown mode, got %v", err)
+	}
+}
@@ -1,10 +1,11 @@
 package dns
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"os"
 	"slices"
@@ -13,7 +14,14 @@ import (
 	"sync"
 	"time"
 
+	"github.com/rqlite/rqlite-disco-clients/disco"
 	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/internal/config"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/internal/tracing"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -22,22 +30,40 @@ const (
 
 // Client is a type can resolve a host for use by rqlite.
 type Client struct {
-	name string
-	port int
+	name        string
+	port        int
+	retryPolicy *retry.Policy
+	timeout     time.Duration
+	clock       disco.Clock
+	metrics     *metrics.Registry
+	tracer      trace.Tracer
+
+	// noAddressesErr makes lookups which yield no addresses fail with
+	// disco.ErrNoAddresses.
+	noAddressesErr bool
 
 	mu            sync.Mutex
 	lastContact   time.Time
 	lastAddresses []string
 	lastError     error
-	logger        *log.Logger
+	retries       uint64
+	logger        *slog.Logger
 
-	// Can be explicitly set for test purposes.
-	lookupFn func(host string) ([]net.IP, error)
+	// Set from the resolver of the client, and can be replaced in tests.
+	lookupFn func(ctx context.Context, network, host string) ([]net.IP, error)
 }
 
+var (
+	_ disco.Lookuper = (*Client)(nil)
+	_ disco.Client   = (*Client)(nil)
+)
+
 // NewConfigFromReader returns a Client configuration from the data read
 // from r. If r is nil, a nil Configuration is returned.
-func NewConfigFromReader(r io.Reader) (*Config, error) {
+// The data may be written in JSON, YAML or TOML, which is detected from its
+// content. Environment variables are expanded as configured by opts, such
+// as expand.Strict, and the Config is checked with Validate.
+func NewConfigFromReader(r io.Reader, opts ...expand.Option) (*Config, error) {
 	if r == nil {
 		return nil, nil
 	}
@@ -46,7 +72,10 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 		return nil, err
 	}
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal("", b, &cfg, opts...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -55,33 +84,31 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 // New returns an instantiated DNS client. If the cfg is nil, the default
 // config is used.
 func New(cfg *Config) *Client {
-	client := &Client{
-		name:     "rqlite",
-		port:     4001,
-		logger:   log.New(os.Stderr, "[disco-dns] ", log.LstdFlags),
-		lookupFn: net.LookupIP,
-	}
-
-	if cfg != nil {
-		if cfg.Name != "" {
-			client.name = cfg.Name
-		}
-		if cfg.Port != 0 {
-			client.port = cfg.Port
-		}
-	}
-	return client
+	return NewClient(cfg)
 }
 
 // NewWithPort returns an instantiated DNS client, with an explicit default port.
 // If the cfg is nil, the default config is used but the port is overridden when
 // using the default config.
 func NewWithPort(cfg *Config, port int) *Client {
+	return NewClient(cfg, WithDefaultPort(port))
+}
+
+// NewClient returns an instantiated DNS client, configured by cfg and opts.
+// If the cfg is nil, the default config is used.
+func NewClient(cfg *Config, opts ...Option) *Client {
+	o := newOptions(cfg, opts)
 	client := &Client{
-		name:     "rqlite",
-		port:     port,
-		logger:   log.New(os.Stderr, "[disco-dns] ", log.LstdFlags),
-		lookupFn: net.LookupIP,
+		name:           "rqlite",
+		port:           o.defaultPort,
+		retryPolicy:    o.retry,
+		timeout:        o.timeout,
+		clock:          o.clock,
+		metrics:        o.metrics,
+		tracer:         tracing.Tracer(o.tracerProvider),
+		noAddressesErr: o.noAddressesErr,
+		logger:         o.logger.With(logging.KeyBackend, "dns"),
+		lookupFn:       o.resolver.LookupIP,
 	}
 
 	if cfg != nil {
@@ -95,16 +122,59 @@ func NewWithPort(cfg *Config, port int) *Client {
 	return client
 }
 
+func init() {
+	disco.Register("dns", newFromConfig)
+}
+
+// newFromConfig is the disco.Factory of DNS clients.
+func newFromConfig(r io.Reader, o *disco.Options) (disco.Client, error) {
+	var expandOpts []expand.Option
+	if o.StrictEnv {
+		expandOpts = append(expandOpts, expand.Strict())
+	}
+	cfg, err := NewConfigFromReader(r, expandOpts...)
+	if err != nil {
+		return nil, err
+	}
+	opts := []Option{WithLogger(o.Logger), WithTimeout(o.Timeout),
+		WithClock(o.Clock), WithTracerProvider(o.TracerProvider)}
+	if o.DefaultPort != 0 {
+		opts = append(opts, WithDefaultPort(o.DefaultPort))
+	}
+	return NewClient(cfg, opts...), nil
+}
+
 // Lookup returns the network addresses resolved for the client's host value.
+// If there are none, an empty list is returned, unless the client was
+// created with WithNoAddressesError.
 //
 // If the environment variable RQLITE_DISCO_DNS_HOSTS is set, its value is used
 // instead of that used by DNS resolution. That value is a comma-separated list
 // of addresses, each of which is a host:port pair. This is useful for testing,
 // and is not suitable for production use.
 func (c *Client) Lookup() ([]string, error) {
+	return c.LookupContext(context.Background())
+}
+
+// LookupContext is like Lookup, but the DNS resolution is bound to ctx.
+func (c *Client) LookupContext(ctx context.Context) ([]string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
 
+	ctx, span := tracing.Start(ctx, c.tracer, "dns", "lookup", tracing.KeyName.String(c.name))
+	addrs, err := c.lookup(ctx)
+	tracing.SetRecordCount(ctx, len(addrs))
+	tracing.End(span, err)
+	return addrs, err
+}
+
+// lookup implements LookupContext. c.mu must be held.
+func (c *Client) lookup(ctx context.Context) ([]string, error) {
 	var addrs []string
 	val, ok := os.LookupEnv(DNSOverrideEnv)
 	if ok {
@@ -117,11 +187,28 @@ func (c *Client) Lookup() ([]string, error) {
 		}
 	} else {
 		var ips []net.IP
-		ips, c.lastError = c.lookupFn(c.name)
+		var retries int
+		start := c.clock.Now()
+		retries, c.lastError = c.retryPolicy.Do(ctx, retry.Transient, func(ctx context.Context) (err error) {
+			ips, err = c.lookupFn(ctx, "ip", c.name)
+			return err
+		})
+		d := c.clock.Now().Sub(start)
+		c.retries += uint64(retries)
+		if c.lastError == nil && len(ips) == 0 && c.noAddressesErr {
+			c.lastError = fmt.Errorf("%w for %s", disco.ErrNoAddresses, c.name)
+		}
 		if c.lastError != nil {
+			c.lastError = disco.WrapError("dns", "lookup", errorKind(c.lastError), c.lastError)
+			c.metrics.ObserveOp("dns", "lookup", d, c.lastError)
+			c.logger.Debug("operation failed", logging.KeyOp, "lookup", logging.KeyName, c.name,
+				logging.KeyRetries, retries, logging.KeyDuration, d, logging.KeyError, c.lastError)
 			return nil, c.lastError
 		}
-		c.lastContact = time.Now()
+		c.lastContact = c.clock.Now()
+		c.metrics.ObserveOp("dns", "lookup", d, nil)
+		c.logger.Debug("operation completed", logging.KeyOp, "lookup", logging.KeyName, c.name,
+			logging.KeyCount, len(ips), logging.KeyRetries, retries, logging.KeyDuration, d)
 
 		addrs = make([]string, len(ips))
 		for i := range ips {
@@ -132,13 +219,33 @@ func (c *Client) Lookup() ([]string, error) {
 	// Record and log the resolved addresses if they have changed.
 	slices.Sort(addrs)
 	if !slices.Equal(c.lastAddresses, addrs) {
-		c.logger.Printf("resolved addresses: %v", addrs)
+		c.logger.Info("resolved addresses", logging.KeyAddresses, addrs)
 		c.lastAddresses = make([]string, len(addrs))
 		copy(c.lastAddresses, addrs)
 	}
 	return addrs, nil
 }
 
+// errorKind returns the sentinel error classifying err, returned by DNS
+// resolution, or nil if the cause of err is not known.
+func errorKind(err error) error {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, disco.ErrNoAddresses):
+		return disco.ErrNoAddresses
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return disco.ErrNotFound
+	case retry.Transient(err):
+		return disco.ErrUnavailable
+	}
+	return nil
+}
+
+// String returns the mode of the client, implementing disco.Client.
+func (c *Client) String() string {
+	return "dns"
+}
+
 // Stats returns some basic diagnostics information about the client.
 func (c *Client) Stats() (map[string]interface{}, error) {
 	c.mu.Lock()
@@ -150,6 +257,10 @@ func (c *Client) Stats() (map[string]interface{}, error) {
 		"port": c.port,
 	}
 
+	if c.retryPolicy != nil {
+		stats["retries"] = c.retries
+	}
+
 	if c.lastError != nil {
 		stats["last_error"] = c.lastError.Error()
 	}
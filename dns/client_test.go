@@ -1,10 +1,25 @@
 package dns
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
 	"net"
 	"os"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_NewClient(t *testing.T) {
@@ -27,7 +42,7 @@ func Test_ClientStats(t *testing.T) {
 
 func Test_ClientLookupSingle(t *testing.T) {
 	client := New(nil)
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := "rqlite", host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -66,7 +81,7 @@ func Test_ClientLookupSingle_Env(t *testing.T) {
 
 func Test_ClientLookupSingleIPv6(t *testing.T) {
 	client := New(nil)
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := "rqlite", host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -88,7 +103,7 @@ func Test_ClientLookupSingleIPv6(t *testing.T) {
 
 func Test_ClientLookupSingleWithPort(t *testing.T) {
 	client := NewWithPort(nil, 5001)
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := "rqlite", host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -112,7 +127,7 @@ func Test_ClientLookupDouble(t *testing.T) {
 	client := New(nil)
 	client.name = "qux"
 	client.port = 8080
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := client.name, host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -179,3 +194,284 @@ func Test_ClientLookupLocalhost(t *testing.T) {
 	}
 	t.Fatalf("failed to get local address %s", addrs)
 }
+
+func Test_ClientLookupContextCanceled(t *testing.T) {
+	client := New(nil)
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.LookupContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func Test_ClientLookupRetry(t *testing.T) {
+	client := New(&Config{Retry: &retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	calls := 0
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		calls++
+		if calls < 3 {
+			return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+		}
+		return []net.IP{net.IPv4(8, 8, 8, 8)}, nil
+	}
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	if !reflect.DeepEqual(addrs, []string{"8.8.8.8:4001"}) {
+		t.Fatalf("failed to get correct address: %s", addrs)
+	}
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if exp, got := uint64(2), stats["retries"]; exp != got {
+		t.Fatalf("wrong number of retries, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_ClientLookupNoRetryNotFound(t *testing.T) {
+	client := New(&Config{Retry: &retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	calls := 0
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		calls++
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	if _, err := client.Lookup(); err == nil {
+		t.Fatalf("expected error for unknown host")
+	}
+	if calls != 1 {
+		t.Fatalf("wrong number of lookups, exp 1, got %d", calls)
+	}
+}
+
+func Test_ClientLookupNotFound(t *testing.T) {
+	client := New(nil)
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	_, err := client.Lookup()
+	if !errors.Is(err, disco.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	var dnsErr *net.DNSError
+	if !errors.As(err, &dnsErr) {
+		t.Fatalf("expected *net.DNSError, got %T", err)
+	}
+}
+
+func Test_ClientLookupNoAddresses(t *testing.T) {
+	client := New(nil)
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return []net.IP{}, nil
+	}
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	if addrs == nil || len(addrs) != 0 {
+		t.Fatalf("expected an empty list of addresses, got %v", addrs)
+	}
+
+	client = NewClient(nil, WithNoAddressesError())
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return []net.IP{}, nil
+	}
+
+	_, err = client.Lookup()
+	if !errors.Is(err, disco.ErrNoAddresses) {
+		t.Fatalf("expected ErrNoAddresses, got %v", err)
+	}
+}
+
+func Test_ClientLogger(t *testing.T) {
+	var buf bytes.Buffer
+	client := NewClient(nil,
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return []net.IP{net.IPv4(8, 8, 8, 8)}, nil
+	}
+
+	if _, err := client.Lookup(); err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	for _, exp := range []string{
+		`"msg":"operation completed","backend":"dns","op":"lookup","name":"rqlite","count":1`,
+		`"msg":"resolved addresses","backend":"dns","addresses":["8.8.8.8:4001"]`,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Fatalf("log does not contain %s: %s", exp, buf.String())
+		}
+	}
+}
+
+func Test_NewClientOptions(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := NewClient(&Config{Name: "rqlite.local"},
+		WithResolver(resolverFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+			if exp, got := "rqlite.local", host; exp != got {
+				t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
+			}
+			return []net.IP{net.IPv4(8, 8, 8, 8)}, nil
+		})),
+		WithDefaultPort(5000),
+		WithClock(testutil.FixedClock(now)),
+		WithRetry(&retry.Policy{MaxAttempts: 2}),
+	)
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	if !reflect.DeepEqual(addrs, []string{"8.8.8.8:5000"}) {
+		t.Fatalf("failed to get correct address: %s", addrs)
+	}
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if got := stats["last_contact"]; got != now {
+		t.Fatalf("wrong last_contact, exp %v, got %v", now, got)
+	}
+	if _, ok := stats["retries"]; !ok {
+		t.Fatalf("retries missing from stats with retry policy: %v", stats)
+	}
+}
+
+func Test_NewFromConfig(t *testing.T) {
+	l, err := disco.NewLookuperFromConfig("dns", strings.NewReader(`{"name": "rqlite.local"}`),
+		disco.WithDefaultPort(5000))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	client := l.(*Client)
+	if client.name != "rqlite.local" || client.port != 5000 {
+		t.Fatalf("wrong name or port: %s %d", client.name, client.port)
+	}
+	if exp, got := "dns", client.String(); exp != got {
+		t.Fatalf("wrong mode, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_NewFromConfigStrictEnv(t *testing.T) {
+	cfg := `{"name": "${DNS_NAME}", "port": ${DNS_PORT:-4002}}`
+	c, err := disco.NewFromConfig("dns", strings.NewReader(cfg))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	if client := c.(*Client); client.name != "rqlite" || client.port != 4002 {
+		t.Fatalf("wrong name or port: %s %d", client.name, client.port)
+	}
+
+	_, err = disco.NewFromConfig("dns", strings.NewReader(cfg), disco.WithStrictEnv())
+	if err == nil || err.Error() != "undefined environment variable: DNS_NAME" {
+		t.Fatalf("expected error for undefined variable, got %v", err)
+	}
+}
+
+func Test_NewWithPort(t *testing.T) {
+	if exp, got := 5000, NewWithPort(nil, 5000).port; exp != got {
+		t.Fatalf("wrong port, exp %d, got %d", exp, got)
+	}
+	if exp, got := 6000, NewWithPort(&Config{Port: 6000}, 5000).port; exp != got {
+		t.Fatalf("wrong port, exp %d, got %d", exp, got)
+	}
+}
+
+func Test_ClientLookupTimeout(t *testing.T) {
+	client := NewClient(nil,
+		WithResolver(resolverFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		})),
+		WithTimeout(10*time.Millisecond),
+	)
+
+	_, err := client.Lookup()
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+type resolverFunc func(ctx context.Context, network, host string) ([]net.IP, error)
+
+func (f resolverFunc) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	return f(ctx, network, host)
+}
+
+func Test_ClientMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	found := true
+	client := NewClient(nil, WithMetrics(reg), WithResolver(resolverFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+		if !found {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		}
+		return []net.IP{net.IPv4(8, 8, 8, 8)}, nil
+	})))
+
+	if _, err := client.Lookup(); err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	found = false
+	if _, err := client.Lookup(); err == nil {
+		t.Fatalf("expected error for unresolvable host")
+	}
+
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatalf("failed to write metrics: %s", err.Error())
+	}
+	for _, exp := range []string{
+		`disco_operations_total{backend="dns",op="lookup"} 2`,
+		`disco_errors_total{backend="dns",op="lookup",kind="not_found"} 1`,
+		`disco_operation_duration_seconds_count{backend="dns",op="lookup"} 2`,
+	} {
+		if !strings.Contains(b.String(), exp) {
+			t.Fatalf("metrics do not contain %s:\n%s", exp, b.String())
+		}
+	}
+}
+
+func Test_ClientTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	client := NewClient(nil, WithTracerProvider(tp),
+		WithResolver(resolverFunc(func(ctx context.Context, network, host string) ([]net.IP, error) {
+			return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+		})))
+
+	if _, err := client.Lookup(); err == nil {
+		t.Fatalf("expected error for unresolvable host")
+	}
+
+	spans := sr.Ended()
+	if exp, got := 1, len(spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	span := spans[0]
+	if exp, got := "dns.lookup", span.Name(); exp != got {
+		t.Fatalf("wrong span name, exp %s, got %s", exp, got)
+	}
+	if exp, got := codes.Error, span.Status().Code; exp != got {
+		t.Fatalf("wrong span status, exp %v, got %v", exp, got)
+	}
+	for key, exp := range map[attribute.Key]string{
+		"disco.backend": "dns",
+		"disco.name":    "rqlite",
+		"disco.result":  "error",
+	} {
+		if v, _ := testutil.SpanAttr(span, key); v.AsString() != exp {
+			t.Fatalf("wrong value of %s, exp %s, got %v", key, exp, v.Emit())
+		}
+	}
+}
@@ -1,5 +1,10 @@
 package dns
 
+import (
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
 const (
 	// exampleConfig is an example of how the DNS config file
 	// should be structured. In this example 'rqlite' is the
@@ -13,7 +18,10 @@ const (
 	exampleConfig = `
 {
 	"name": "rqlite",
-	"port": 4002
+	"port": 4002,
+	"retry": {
+		"max_attempts": 3
+	}
 }
 `
 )
@@ -25,4 +33,22 @@ type Config struct {
 
 	// Port is the port resolved names will be listening on.
 	Port int `json:"port,omitempty"`
+
+	// Retry is the policy for retrying lookups which fail with transient
+	// errors. Lookups are not retried if it is not set.
+	Retry *retry.Policy `json:"retry,omitempty"`
+}
+
+// Validate checks the values of c, returning a *disco.ConfigError listing
+// every invalid field. A nil Config is valid.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	var v disco.Validator
+	if c.Port < 0 || c.Port > 65535 {
+		v.Add("port", "must be between 0 and 65535 (0 selects the default)")
+	}
+	v.Nested("retry", c.Retry.Validate())
+	return v.Err()
 }
@@ -1,8 +1,11 @@
 package dns
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
 )
 
 func Test_NilReaderConfig(t *testing.T) {
@@ -28,4 +31,53 @@ func Test_LoadExampleConfig(t *testing.T) {
 	if cfg.Name != "rqlite" || cfg.Port != 4002 {
 		t.Fatalf("invalid config generated")
 	}
+
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 3 {
+		t.Fatalf("retry policy not parsed: %+v", cfg.Retry)
+	}
+}
+
+func Test_LoadYAMLTOMLConfig(t *testing.T) {
+	t.Setenv("DNS_NAME", "rqlite.local")
+	for name, data := range map[string]string{
+		"YAML": `name: ${DNS_NAME}
+port: 4002
+retry:
+  max_attempts: 3
+`,
+		"TOML": `name = "${DNS_NAME}"
+port = 4002
+
+[retry]
+max_attempts = 3
+`,
+	} {
+		cfg, err := NewConfigFromReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", name, err.Error())
+		}
+		if cfg.Name != "rqlite.local" || cfg.Port != 4002 {
+			t.Fatalf("invalid config generated from %s: %+v", name, cfg)
+		}
+		if cfg.Retry == nil || cfg.Retry.MaxAttempts != 3 {
+			t.Fatalf("retry policy not parsed from %s: %+v", name, cfg.Retry)
+		}
+	}
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	if err := (&Config{Name: "rqlite", Port: 4001}).Validate(); err != nil {
+		t.Fatalf("valid config is invalid: %s", err)
+	}
+	if err := (&Config{Name: "rqlite"}).Validate(); err != nil {
+		t.Fatalf("config with default port is invalid: %s", err)
+	}
+
+	_, err := NewConfigFromReader(strings.NewReader(`{"port": -1, "retry": {"deadline": -1}}`))
+	if exp, got := []string{"port", "retry.deadline"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	if exp, got := []string{"port"}, testutil.InvalidFields(t, (&Config{Port: 65536}).Validate()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
 }
@@ -1,38 +1,63 @@
 package dnssrv
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
-	"os"
 	"slices"
 	"sync"
 	"time"
 
+	"github.com/rqlite/rqlite-disco-clients/disco"
 	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/internal/config"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/internal/tracing"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Client is a type can retrieve SRV records for rqlite
 type Client struct {
-	name    string
-	service string
+	name        string
+	service     string
+	retryPolicy *retry.Policy
+	timeout     time.Duration
+	clock       disco.Clock
+	metrics     *metrics.Registry
+	tracer      trace.Tracer
+
+	// noAddressesErr makes lookups which yield no addresses fail with
+	// disco.ErrNoAddresses.
+	noAddressesErr bool
 
 	mu            sync.Mutex
 	lastContact   time.Time
 	lastAddresses []string
 	lastError     error
-	logger        *log.Logger
+	retries       uint64
+	logger        *slog.Logger
 
-	// Can be explicitly set for test purposes.
-	lookupSRVFn func(service, proto, name string) (string, []*net.SRV, error)
-	lookupFn    func(host string) ([]net.IP, error)
+	// Set from the resolver of the client, and can be replaced in tests.
+	lookupSRVFn func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	lookupFn    func(ctx context.Context, network, host string) ([]net.IP, error)
 }
 
+var (
+	_ disco.Lookuper = (*Client)(nil)
+	_ disco.Client   = (*Client)(nil)
+)
+
 // NewConfigFromReader returns a Client configuration from the data read
 // from r. If r is nil, a nil Configuration is returned.
-func NewConfigFromReader(r io.Reader) (*Config, error) {
+// The data may be written in JSON, YAML or TOML, which is detected from its
+// content. Environment variables are expanded as configured by opts, such
+// as expand.Strict, and the Config is checked with Validate.
+func NewConfigFromReader(r io.Reader, opts ...expand.Option) (*Config, error) {
 	if r == nil {
 		return nil, nil
 	}
@@ -41,7 +66,10 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 		return nil, err
 	}
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal("", b, &cfg, opts...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -50,12 +78,25 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 // New returns an instantiated DNS SRV client. If the cfg is nil, the default
 // config is used.
 func New(cfg *Config) *Client {
+	return NewClient(cfg)
+}
+
+// NewClient returns an instantiated DNS SRV client, configured by cfg and
+// opts. If the cfg is nil, the default config is used.
+func NewClient(cfg *Config, opts ...Option) *Client {
+	o := newOptions(cfg, opts)
 	client := &Client{
-		name:        "rqlite",
-		service:     "rqlite",
-		logger:      log.New(os.Stderr, "[disco-dnssrv] ", log.LstdFlags),
-		lookupSRVFn: net.LookupSRV,
-		lookupFn:    net.LookupIP,
+		name:           "rqlite",
+		service:        "rqlite",
+		retryPolicy:    o.retry,
+		timeout:        o.timeout,
+		clock:          o.clock,
+		metrics:        o.metrics,
+		tracer:         tracing.Tracer(o.tracerProvider),
+		noAddressesErr: o.noAddressesErr,
+		logger:         o.logger.With(logging.KeyBackend, "dns-srv"),
+		lookupSRVFn:    o.resolver.LookupSRV,
+		lookupFn:       o.resolver.LookupIP,
 	}
 
 	if cfg != nil {
@@ -69,40 +110,147 @@ func New(cfg *Config) *Client {
 	return client
 }
 
-// Lookup returns the network addresses from the DNS SRV records
+func init() {
+	disco.Register("dns-srv", newFromConfig)
+}
+
+// newFromConfig is the disco.Factory of DNS SRV clients.
+func newFromConfig(r io.Reader, o *disco.Options) (disco.Client, error) {
+	var expandOpts []expand.Option
+	if o.StrictEnv {
+		expandOpts = append(expandOpts, expand.Strict())
+	}
+	cfg, err := NewConfigFromReader(r, expandOpts...)
+	if err != nil {
+		return nil, err
+	}
+	opts := []Option{WithLogger(o.Logger), WithTimeout(o.Timeout),
+		WithClock(o.Clock), WithTracerProvider(o.TracerProvider)}
+	return NewClient(cfg, opts...), nil
+}
+
+// Lookup returns the network addresses from the DNS SRV records. If there
+// are none, an empty list is returned, unless the client was created with
+// WithNoAddressesError.
 func (c *Client) Lookup() ([]string, error) {
+	return c.LookupContext(context.Background())
+}
+
+// LookupContext is like Lookup, but the DNS resolution of both the SRV
+// records and their targets is bound to ctx.
+func (c *Client) LookupContext(ctx context.Context) ([]string, error) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
 
-	var records []*net.SRV
-	_, records, c.lastError = c.lookupSRVFn(c.service, "tcp", c.name)
+	ctx, span := tracing.Start(ctx, c.tracer, "dns-srv", "lookup",
+		tracing.KeyName.String(fmt.Sprintf("_%s._tcp.%s", c.service, c.name)))
+	addrs, err := c.lookup(ctx)
+	tracing.SetRecordCount(ctx, len(addrs))
+	tracing.End(span, err)
+	return addrs, err
+}
+
+// lookup implements LookupContext. c.mu must be held.
+func (c *Client) lookup(ctx context.Context) ([]string, error) {
+	var addrs []string
+	var retries int
+	start := c.clock.Now()
+	retries, c.lastError = c.retryPolicy.Do(ctx, retry.Transient, func(ctx context.Context) (err error) {
+		addrs, err = c.resolve(ctx)
+		return err
+	})
+	d := c.clock.Now().Sub(start)
+	c.retries += uint64(retries)
+	if c.lastError == nil && len(addrs) == 0 && c.noAddressesErr {
+		c.lastError = fmt.Errorf("%w for _%s._tcp.%s", disco.ErrNoAddresses, c.service, c.name)
+	}
 	if c.lastError != nil {
+		c.lastError = disco.WrapError("dns-srv", "lookup", errorKind(c.lastError), c.lastError)
+		c.metrics.ObserveOp("dns-srv", "lookup", d, c.lastError)
+		c.logger.Debug("operation failed", logging.KeyOp, "lookup", logging.KeyName, c.name,
+			logging.KeyRetries, retries, logging.KeyDuration, d, logging.KeyError, c.lastError)
 		return nil, c.lastError
 	}
-	c.lastContact = time.Now()
+	c.metrics.ObserveOp("dns-srv", "lookup", d, nil)
+	c.logger.Debug("operation completed", logging.KeyOp, "lookup", logging.KeyName, c.name,
+		logging.KeyCount, len(addrs), logging.KeyRetries, retries, logging.KeyDuration, d)
+
+	slices.Sort(addrs)
+	if !slices.Equal(c.lastAddresses, addrs) {
+		c.logger.Info("resolved addresses", logging.KeyAddresses, addrs)
+		c.lastAddresses = make([]string, len(addrs))
+		copy(c.lastAddresses, addrs)
+	}
+	return addrs, nil
+}
+
+// resolve makes a single attempt at resolving the SRV records, and the
+// addresses of their targets. c.mu must be held.
+func (c *Client) resolve(ctx context.Context) ([]string, error) {
+	records, err := c.lookupSRV(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.lastContact = c.clock.Now()
 
 	addrs := make([]string, 0)
 	for i := range records {
 		// Now look up the IP address for the target. If there are more than
 		// one, add them all.
-		var ips []net.IP
-		ips, c.lastError = c.lookupFn(records[i].Target)
-		if c.lastError != nil {
-			return nil, c.lastError
+		ips, err := c.lookupIP(ctx, records[i].Target)
+		if err != nil {
+			return nil, err
 		}
 
 		for j := range ips {
 			addrs = append(addrs, net.JoinHostPort(ips[j].String(), fmt.Sprintf("%d", records[i].Port)))
 		}
 	}
+	return addrs, nil
+}
 
-	slices.Sort(addrs)
-	if !slices.Equal(c.lastAddresses, addrs) {
-		c.logger.Printf("resolved addresses: %v", addrs)
-		c.lastAddresses = make([]string, len(addrs))
-		copy(c.lastAddresses, addrs)
+// lookupSRV looks up the SRV records of the client, in a span of its own.
+func (c *Client) lookupSRV(ctx context.Context) ([]*net.SRV, error) {
+	ctx, span := tracing.Start(ctx, c.tracer, "dns-srv", "lookup_srv",
+		tracing.KeyName.String(fmt.Sprintf("_%s._tcp.%s", c.service, c.name)))
+	_, records, err := c.lookupSRVFn(ctx, c.service, "tcp", c.name)
+	tracing.SetRecordCount(ctx, len(records))
+	tracing.End(span, err)
+	return records, err
+}
+
+// lookupIP looks up the IP addresses of target, in a span of its own.
+func (c *Client) lookupIP(ctx context.Context, target string) ([]net.IP, error) {
+	ctx, span := tracing.Start(ctx, c.tracer, "dns-srv", "lookup_ip", tracing.KeyName.String(target))
+	ips, err := c.lookupFn(ctx, "ip", target)
+	tracing.SetRecordCount(ctx, len(ips))
+	tracing.End(span, err)
+	return ips, err
+}
+
+// errorKind returns the sentinel error classifying err, returned by DNS
+// resolution, or nil if the cause of err is not known.
+func errorKind(err error) error {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, disco.ErrNoAddresses):
+		return disco.ErrNoAddresses
+	case errors.As(err, &dnsErr) && dnsErr.IsNotFound:
+		return disco.ErrNotFound
+	case retry.Transient(err):
+		return disco.ErrUnavailable
 	}
-	return addrs, nil
+	return nil
+}
+
+// String returns the mode of the client, implementing disco.Client.
+func (c *Client) String() string {
+	return "dns-srv"
 }
 
 // Stats returns some basic diagnostics information about the client.
@@ -117,6 +265,10 @@ func (c *Client) Stats() (map[string]interface{}, error) {
 		"dns_name:": fmt.Sprintf("_%s._tcp.%s.", c.service, c.name),
 	}
 
+	if c.retryPolicy != nil {
+		stats["retries"] = c.retries
+	}
+
 	if c.lastError != nil {
 		stats["last_error"] = c.lastError.Error()
 	}
@@ -1,9 +1,23 @@
 package dnssrv
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net"
 	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 )
 
 func Test_NewClient(t *testing.T) {
@@ -27,7 +41,7 @@ func Test_ClientStats(t *testing.T) {
 func Test_ClientLookupSingle(t *testing.T) {
 	client := New(nil)
 
-	lookupSRVFn := func(service, proto, name string) (string, []*net.SRV, error) {
+	lookupSRVFn := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
 		if exp, got := "rqlite", service; exp != got {
 			t.Fatalf("incorrect service resolved, exp %s, got %s", exp, got)
 		}
@@ -43,7 +57,7 @@ func Test_ClientLookupSingle(t *testing.T) {
 	}
 	client.lookupSRVFn = lookupSRVFn
 
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := "rqlite.node", host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -66,7 +80,7 @@ func Test_ClientLookupSingle(t *testing.T) {
 func Test_ClientLookupSingleIPv6(t *testing.T) {
 	client := New(nil)
 
-	lookupSRVFn := func(service, proto, name string) (string, []*net.SRV, error) {
+	lookupSRVFn := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
 		if exp, got := "rqlite", service; exp != got {
 			t.Fatalf("incorrect service resolved, exp %s, got %s", exp, got)
 		}
@@ -82,7 +96,7 @@ func Test_ClientLookupSingleIPv6(t *testing.T) {
 	}
 	client.lookupSRVFn = lookupSRVFn
 
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if exp, got := "rqlite.node", host; exp != got {
 			t.Fatalf("incorrect host resolved, exp %s, got %s", exp, got)
 		}
@@ -107,7 +121,7 @@ func Test_ClientLookupDouble(t *testing.T) {
 	client.name = "rqlite-name"
 	client.service = "rqlite-service"
 
-	lookupSRVFn := func(service, proto, name string) (string, []*net.SRV, error) {
+	lookupSRVFn := func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
 		if exp, got := "rqlite-service", service; exp != got {
 			t.Fatalf("incorrect service resolved, exp %s, got %s", exp, got)
 		}
@@ -131,7 +145,7 @@ func Test_ClientLookupDouble(t *testing.T) {
 	}
 	client.lookupSRVFn = lookupSRVFn
 
-	lookupFn := func(host string) ([]net.IP, error) {
+	lookupFn := func(ctx context.Context, network, host string) ([]net.IP, error) {
 		if host == "rqlite.node.1" {
 			return []net.IP{net.IPv4(1, 1, 1, 1)}, nil
 		} else if host == "rqlite.node.2" {
@@ -153,3 +167,252 @@ func Test_ClientLookupDouble(t *testing.T) {
 		t.Fatalf("failed to get correct address: %s", addrs)
 	}
 }
+
+func Test_ClientLookupContextCanceled(t *testing.T) {
+	client := New(nil)
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "rqlite.node", Port: 1000}}, nil
+	}
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err := client.LookupContext(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline exceeded error, got %v", err)
+	}
+}
+
+func Test_ClientLookupRetry(t *testing.T) {
+	client := New(&Config{Retry: &retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+	srvCalls := 0
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		srvCalls++
+		if srvCalls == 1 {
+			return "", nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+		}
+		return "", []*net.SRV{{Target: "rqlite.node", Port: 1000}}, nil
+	}
+	ipCalls := 0
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		ipCalls++
+		if ipCalls == 1 {
+			return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+		}
+		return []net.IP{net.IPv4(1, 1, 1, 1)}, nil
+	}
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup SRV record: %s", err.Error())
+	}
+	if !reflect.DeepEqual(addrs, []string{"1.1.1.1:1000"}) {
+		t.Fatalf("failed to get correct address: %s", addrs)
+	}
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if exp, got := uint64(2), stats["retries"]; exp != got {
+		t.Fatalf("wrong number of retries, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_ClientLookupNoAddresses(t *testing.T) {
+	client := New(nil)
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{}, nil
+	}
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup host: %s", err.Error())
+	}
+	if addrs == nil || len(addrs) != 0 {
+		t.Fatalf("expected an empty list of addresses, got %v", addrs)
+	}
+
+	client = NewClient(nil, WithNoAddressesError())
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{}, nil
+	}
+
+	_, err = client.Lookup()
+	if !errors.Is(err, disco.ErrNoAddresses) {
+		t.Fatalf("expected ErrNoAddresses, got %v", err)
+	}
+}
+
+func Test_ClientLookupTargetUnavailable(t *testing.T) {
+	client := New(nil)
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "rqlite.node", Port: 1000}}, nil
+	}
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: host, IsTemporary: true}
+	}
+
+	_, err := client.Lookup()
+	if !errors.Is(err, disco.ErrUnavailable) {
+		t.Fatalf("expected ErrUnavailable, got %v", err)
+	}
+}
+
+func Test_ClientLogger(t *testing.T) {
+	var buf bytes.Buffer
+	client := NewClient(nil,
+		WithLogger(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))))
+	client.lookupSRVFn = func(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+		return "", []*net.SRV{{Target: "rqlite.node", Port: 1000}}, nil
+	}
+	client.lookupFn = func(ctx context.Context, network, host string) ([]net.IP, error) {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+
+	if _, err := client.Lookup(); err == nil {
+		t.Fatalf("expected error for unresolvable target")
+	}
+	exp := `"msg":"operation failed","backend":"dns-srv","op":"lookup","name":"rqlite","retries":0`
+	if !strings.Contains(buf.String(), exp) {
+		t.Fatalf("log does not contain %s: %s", exp, buf.String())
+	}
+	if strings.Contains(buf.String(), "resolved addresses") {
+		t.Fatalf("log contains resolved addresses after failed lookup: %s", buf.String())
+	}
+}
+
+func Test_NewClientOptions(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	client := NewClient(&Config{Name: "rqlite.local", Service: "raft"},
+		WithResolver(&mockResolver{
+			srv: map[string][]*net.SRV{
+				"_raft._tcp.rqlite.local": {{Target: "rqlite.node.1", Port: 1000}},
+			},
+			ip: map[string][]net.IP{
+				"rqlite.node.1": {net.IPv4(1, 1, 1, 1)},
+			},
+		}),
+		WithClock(testutil.FixedClock(now)),
+		WithTimeout(time.Second),
+	)
+
+	addrs, err := client.Lookup()
+	if err != nil {
+		t.Fatalf("failed to lookup SRV record: %s", err.Error())
+	}
+	if !reflect.DeepEqual(addrs, []string{"1.1.1.1:1000"}) {
+		t.Fatalf("failed to get correct address: %s", addrs)
+	}
+	stats, err := client.Stats()
+	if err != nil {
+		t.Fatalf("failed to get stats: %s", err.Error())
+	}
+	if got := stats["last_contact"]; got != now {
+		t.Fatalf("wrong last_contact, exp %v, got %v", now, got)
+	}
+}
+
+func Test_NewFromConfig(t *testing.T) {
+	l, err := disco.NewLookuperFromConfig("dns-srv", strings.NewReader(`{"name": "rqlite.local", "service": "raft"}`))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	client := l.(*Client)
+	if client.name != "rqlite.local" || client.service != "raft" {
+		t.Fatalf("wrong name or service: %s %s", client.name, client.service)
+	}
+	if exp, got := "dns-srv", client.String(); exp != got {
+		t.Fatalf("wrong mode, exp %s, got %s", exp, got)
+	}
+}
+
+type mockResolver struct {
+	srv map[string][]*net.SRV
+	ip  map[string][]net.IP
+}
+
+func (r *mockResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	target := fmt.Sprintf("_%s._%s.%s", service, proto, name)
+	records, ok := r.srv[target]
+	if !ok {
+		return "", nil, &net.DNSError{Err: "no such host", Name: target, IsNotFound: true}
+	}
+	return target, records, nil
+}
+
+func (r *mockResolver) LookupIP(ctx context.Context, network, host string) ([]net.IP, error) {
+	ips, ok := r.ip[host]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+	}
+	return ips, nil
+}
+
+func Test_ClientMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+	client := NewClient(nil, WithMetrics(reg), WithResolver(&mockResolver{}))
+
+	if _, err := client.Lookup(); err == nil {
+		t.Fatalf("expected error for missing SRV records")
+	}
+
+	var b strings.Builder
+	if err := reg.WritePrometheus(&b); err != nil {
+		t.Fatalf("failed to write metrics: %s", err.Error())
+	}
+	exp := `disco_errors_total{backend="dns-srv",op="lookup",kind="not_found"} 1`
+	if !strings.Contains(b.String(), exp) {
+		t.Fatalf("metrics do not contain %s:\n%s", exp, b.String())
+	}
+}
+
+func Test_ClientTracing(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	client := NewClient(nil, WithTracerProvider(tp), WithResolver(&mockResolver{
+		srv: map[string][]*net.SRV{
+			"_rqlite._tcp.rqlite": {
+				{Target: "rqlite.node.1", Port: 1000},
+				{Target: "rqlite.node.2", Port: 2000},
+			},
+		},
+		ip: map[string][]net.IP{
+			"rqlite.node.1": {net.IPv4(1, 1, 1, 1)},
+			"rqlite.node.2": {net.IPv4(2, 2, 2, 2)},
+		},
+	}))
+
+	if _, err := client.Lookup(); err != nil {
+		t.Fatalf("failed to lookup SRV record: %s", err.Error())
+	}
+
+	spans := sr.Ended()
+	if exp, got := 4, len(spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	var names []string
+	for _, span := range spans {
+		names = append(names, span.Name())
+	}
+	if exp := []string{"dns-srv.lookup_srv", "dns-srv.lookup_ip", "dns-srv.lookup_ip", "dns-srv.lookup"}; !reflect.DeepEqual(exp, names) {
+		t.Fatalf("wrong spans, exp %v, got %v", exp, names)
+	}
+	parent := spans[3]
+	for _, span := range spans[:3] {
+		if span.Parent().SpanID() != parent.SpanContext().SpanID() {
+			t.Fatalf("span %s is not a child of the lookup span", span.Name())
+		}
+	}
+	if v, _ := testutil.SpanAttr(parent, "disco.record_count"); v.AsInt64() != 2 {
+		t.Fatalf("wrong record count, exp 2, got %v", v.Emit())
+	}
+	if v, _ := testutil.SpanAttr(parent, "disco.result"); v.AsString() != "ok" {
+		t.Fatalf("wrong result, exp ok, got %v", v.Emit())
+	}
+	if v, _ := testutil.SpanAttr(spans[1], "disco.name"); v.AsString() != "rqlite.node.1" {
+		t.Fatalf("wrong target name, exp rqlite.node.1, got %v", v.Emit())
+	}
+}
@@ -1,5 +1,12 @@
 package dnssrv
 
+import (
+	"strings"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
 const (
 	// exampleConfig is an example of how the DNS SRV config file
 	// should be structured. 'name' is the host to resolve for the
@@ -17,7 +24,10 @@ const (
 	exampleConfig = `
 {
 	"name": "rqlite.com",
-	"service": "rqlite-raft"
+	"service": "rqlite-raft",
+	"retry": {
+		"max_attempts": 3
+	}
 }
 `
 )
@@ -30,4 +40,22 @@ type Config struct {
 	// Service is the service to request when making the
 	// DNS SRV request.
 	Service string `json:"service,omitempty"`
+
+	// Retry is the policy for retrying lookups which fail with transient
+	// errors. Lookups are not retried if it is not set.
+	Retry *retry.Policy `json:"retry,omitempty"`
+}
+
+// Validate checks the values of c, returning a *disco.ConfigError listing
+// every invalid field. A nil Config is valid.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	var v disco.Validator
+	if strings.HasPrefix(c.Service, "_") {
+		v.Add("service", "should not start with an underscore, which is added when looking up the service")
+	}
+	v.Nested("retry", c.Retry.Validate())
+	return v.Err()
 }
@@ -1,8 +1,11 @@
 package dnssrv
 
 import (
+	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
 )
 
 func Test_NilReaderConfig(t *testing.T) {
@@ -28,4 +31,47 @@ func Test_LoadExampleConfig(t *testing.T) {
 	if cfg.Name != "rqlite.com" || cfg.Service != "rqlite-raft" {
 		t.Fatalf("invalid config generated")
 	}
+
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 3 {
+		t.Fatalf("retry policy not parsed: %+v", cfg.Retry)
+	}
+}
+
+func Test_LoadYAMLTOMLConfig(t *testing.T) {
+	t.Setenv("DNS_NAME", "rqlite.local")
+	for name, data := range map[string]string{
+		"YAML": `name: ${DNS_NAME}
+service: rqlite-raft
+retry:
+  max_attempts: 3
+`,
+		"TOML": `name = "${DNS_NAME}"
+service = "rqlite-raft"
+
+[retry]
+max_attempts = 3
+`,
+	} {
+		cfg, err := NewConfigFromReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", name, err.Error())
+		}
+		if cfg.Name != "rqlite.local" || cfg.Service != "rqlite-raft" {
+			t.Fatalf("invalid config generated from %s: %+v", name, cfg)
+		}
+		if cfg.Retry == nil || cfg.Retry.MaxAttempts != 3 {
+			t.Fatalf("retry policy not parsed from %s: %+v", name, cfg.Retry)
+		}
+	}
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	if err := (&Config{Name: "rqlite.com", Service: "rqlite-raft"}).Validate(); err != nil {
+		t.Fatalf("valid config is invalid: %s", err)
+	}
+
+	_, err := NewConfigFromReader(strings.NewReader(`{"service": "_rqlite-raft", "retry": {"max_backoff": -1}}`))
+	if exp, got := []string{"service", "retry.max_backoff"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
 }
@@ -0,0 +1,124 @@
+package dnssrv
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Resolver resolves SRV records, and the IP addresses of their targets. It
+// is implemented by *net.Resolver.
+type Resolver interface {
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+	LookupIP(ctx context.Context, network, host string) ([]net.IP, error)
+}
+
+// Option configures a Client created by NewClient. Options take precedence
+// over the corresponding fields of the Config.
+type Option func(*options)
+
+type options struct {
+	logger         *slog.Logger
+	resolver       Resolver
+	retry          *retry.Policy
+	timeout        time.Duration
+	clock          disco.Clock
+	metrics        *metrics.Registry
+	tracerProvider trace.TracerProvider
+	noAddressesErr bool
+}
+
+// newOptions returns the options set by cfg, overridden by opts.
+func newOptions(cfg *Config, opts []Option) *options {
+	o := &options{
+		logger:   logging.Std("[disco-dnssrv] "),
+		resolver: net.DefaultResolver,
+		clock:    disco.SystemClock,
+		metrics:  metrics.Default,
+	}
+	if cfg != nil {
+		o.retry = cfg.Retry
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogger sets the logger to which the client logs its lookups. If it is
+// not set, changes of the resolved addresses are logged to stderr, prefixed
+// with "[disco-dnssrv] ".
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithResolver sets the resolver used to look up the SRV records, and the
+// addresses of their targets.
+func WithResolver(r Resolver) Option {
+	return func(o *options) {
+		if r != nil {
+			o.resolver = r
+		}
+	}
+}
+
+// WithRetry sets the policy for retrying lookups which fail with transient
+// errors. A nil policy disables retries.
+func WithRetry(p *retry.Policy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// WithTimeout bounds each lookup, including its retries, by d.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithClock sets the clock used for the statistics of the client.
+func WithClock(clock disco.Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// WithMetrics sets the registry to which the client records its metrics,
+// instead of metrics.Default. A nil registry disables metrics.
+func WithMetrics(r *metrics.Registry) Option {
+	return func(o *options) {
+		o.metrics = r
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer with which the client
+// creates a span for each lookup. The global TracerProvider is used if it is
+// not set.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
+
+// WithNoAddressesError makes lookups which succeed, but yield no addresses,
+// fail with an error wrapping disco.ErrNoAddresses. By default, they return
+// an empty list of addresses and no error.
+func WithNoAddressesError() Option {
+	return func(o *options) {
+		o.noAddressesErr = true
+	}
+}
@@ -3,13 +3,34 @@ package etcd
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log/slog"
 	"os"
+	"sync"
+	"time"
 
+	"github.com/rqlite/rqlite-disco-clients/disco"
 	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/internal/config"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/internal/opstats"
+	"github.com/rqlite/rqlite-disco-clients/internal/tracing"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// watchRetryInterval is how long a watch waits before retrying after
+	// an error.
+	watchRetryInterval = time.Second
 )
 
 // Client represents an etcd client.
@@ -17,10 +38,42 @@ type Client struct {
 	client    *clientv3.Client
 	key       string
 	leaderKey string
+	nodesKey  string
+
+	retryPolicy *retry.Policy
+	timeout     time.Duration
+	clock       disco.Clock
+	logger      *slog.Logger
+	metrics     *metrics.Registry
+	tracer      trace.Tracer
+	stats       opstats.Recorder
+	lastLeader  disco.Leader
+
+	mu          sync.Mutex
+	leaseTTL    time.Duration
+	leaseID     clientv3.LeaseID
+	leaseLostCh chan error
+	leaseCtx    context.Context
+	leaseCancel context.CancelFunc
+
+	electionKey string
+	electionTTL time.Duration
+	election    *election
 }
 
-// NewConfigFromFile parses the file at path and returns a Config.
-func NewConfigFromFile(path string) (*Config, error) {
+var (
+	_ disco.LeaderStore   = (*Client)(nil)
+	_ disco.LeaderWatcher = (*Client)(nil)
+	_ disco.NodeRegistry  = (*Client)(nil)
+	_ disco.Elector       = (*Client)(nil)
+)
+
+// NewConfigFromFile parses the file at path and returns a Config. The file
+// may be written in JSON, YAML or TOML, as given by its extension, or
+// detected from its content if the extension is not known. Environment
+// variables are expanded as configured by opts, such as expand.Strict, the
+// Config is checked with Validate, and the fields read from files are set.
+func NewConfigFromFile(path string, opts ...expand.Option) (*Config, error) {
 	cfgFile, err := os.Open(path)
 	if err != nil {
 		return nil, err
@@ -33,7 +86,13 @@ func NewConfigFromFile(path string) (*Config, error) {
 	}
 
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal(path, b, &cfg, opts...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.readFiles(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -41,7 +100,11 @@ func NewConfigFromFile(path string) (*Config, error) {
 
 // NewConfigFromReader parses the data returned by the reader and
 // returns a Config. A nil reader results in a nil config.
-func NewConfigFromReader(r io.Reader) (*Config, error) {
+// The data may be written in JSON, YAML or TOML, which is detected from its
+// content. Environment variables are expanded as configured by opts, such
+// as expand.Strict, the Config is checked with Validate, and the fields read
+// from files are set.
+func NewConfigFromReader(r io.Reader, opts ...expand.Option) (*Config, error) {
 	if r == nil {
 		return nil, nil
 	}
@@ -50,7 +113,13 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 		return nil, err
 	}
 	var cfg Config
-	if err := json.Unmarshal(expand.ExpandEnvBytes(b), &cfg); err != nil {
+	if err := config.Unmarshal("", b, &cfg, opts...); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := cfg.readFiles(); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -59,6 +128,13 @@ func NewConfigFromReader(r io.Reader) (*Config, error) {
 // New returns an instantiated etcd client. If cfg is nil, use
 // the default config.
 func New(key string, cfg *Config) (*Client, error) {
+	return NewClient(key, cfg)
+}
+
+// NewClient returns an instantiated etcd client, configured by cfg and
+// opts. If cfg is nil, use the default config.
+func NewClient(key string, cfg *Config, opts ...Option) (*Client, error) {
+	o := newOptions(cfg, opts)
 	c, err := clientv3.New(*etcdConfigFromClientConfig(cfg))
 	if err != nil {
 		return nil, err
@@ -67,14 +143,84 @@ func New(key string, cfg *Config) (*Client, error) {
 		client:    c,
 		key:       key,
 		leaderKey: fmt.Sprintf("/%s/leader", key),
+		nodesKey:  fmt.Sprintf("/%s/nodes/", key),
+
+		retryPolicy: o.retry,
+		timeout:     o.timeout,
+		clock:       o.clock,
+		logger:      o.logger.With(logging.KeyBackend, "etcd-kv", logging.KeyKey, key),
+		metrics:     o.metrics,
+		tracer:      tracing.Tracer(o.tracerProvider),
+		stats:       opstats.Recorder{Now: o.clock.Now},
+
+		electionKey: fmt.Sprintf("/%s/election", key),
+		electionTTL: defaultElectionTTL,
 	}, nil
 }
 
-// GetLeader returns the leader as recorded in Consul. If a leader exists, ok will
+func init() {
+	disco.Register("etcd-kv", newFromConfig)
+}
+
+// newFromConfig is the disco.Factory of etcd clients.
+func newFromConfig(r io.Reader, o *disco.Options) (disco.Client, error) {
+	var expandOpts []expand.Option
+	if o.StrictEnv {
+		expandOpts = append(expandOpts, expand.Strict())
+	}
+	cfg, err := NewConfigFromReader(r, expandOpts...)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewClient(o.Key, cfg, WithLogger(o.Logger), WithTimeout(o.Timeout),
+		WithClock(o.Clock), WithTracerProvider(o.TracerProvider))
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetLeader returns the leader as recorded in etcd. If a leader exists, ok will
 // be set to true, false otherwise.
 func (c *Client) GetLeader() (id string, apiAddr string, addr string, ok bool, e error) {
+	return c.GetLeaderContext(context.Background())
+}
+
+// GetLeaderContext is like GetLeader, but the request to etcd is bound
+// to ctx.
+func (c *Client) GetLeaderContext(ctx context.Context) (id string, apiAddr string, addr string, ok bool, e error) {
+	rec, ok, err := c.GetLeaderRecordContext(ctx)
+	if err != nil || !ok {
+		return "", "", "", false, err
+	}
+	return rec.ID, rec.APIAddr, rec.Addr, true, nil
+}
+
+// GetLeaderRecord returns the leader as recorded in etcd, along with the
+// CreateRevision, ModRevision and Version of the record. The ModRevision is
+// also used as the Term. If a leader exists, ok will be set to true, false
+// otherwise.
+func (c *Client) GetLeaderRecord() (rec disco.LeaderRecord, ok bool, e error) {
+	return c.GetLeaderRecordContext(context.Background())
+}
+
+// GetLeaderRecordContext is like GetLeaderRecord, but the request to etcd
+// is bound to ctx.
+func (c *Client) GetLeaderRecordContext(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
+	e = c.do(ctx, "get_leader", func(ctx context.Context) (err error) {
+		rec, ok, err = c.getLeaderRecord(ctx)
+		if err == nil {
+			tracing.SetFound(ctx, ok)
+		}
+		return err
+	})
+	return
+}
+
+// getLeaderRecord makes a single attempt at GetLeaderRecordContext.
+func (c *Client) getLeaderRecord(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
 	kv := clientv3.NewKV(c.client)
-	resp, err := kv.Get(context.Background(), c.leaderKey)
+	resp, err := kv.Get(ctx, c.leaderKey)
 	if err != nil {
 		e = err
 		return
@@ -85,31 +231,65 @@ func (c *Client) GetLeader() (id string, apiAddr string, addr string, ok bool, e
 	}
 
 	n := node{}
-	if err := json.Unmarshal(resp.Kvs[0].Value, &n); err != nil {
+	if err := decodeNode(resp.Kvs[0].Value, &n); err != nil {
 		e = err
 		return
 	}
-	return n.ID, n.APIAddr, n.Addr, true, nil
+	c.sawLeader(n.leader())
+	return disco.LeaderRecord{
+		Leader:         n.leader(),
+		CreateRevision: uint64(resp.Kvs[0].CreateRevision),
+		ModRevision:    uint64(resp.Kvs[0].ModRevision),
+		Version:        uint64(resp.Kvs[0].Version),
+		Term:           uint64(resp.Kvs[0].ModRevision),
+		UpdatedAt:      n.UpdatedAt,
+	}, true, nil
 }
 
 // InitializeLeader sets the leader to the given details, but only if no leader
 // has already been set. This operation is a check-and-set type operation. If
 // initialization succeeds, ok is set to true.
 func (c *Client) InitializeLeader(id, apiAddr, addr string) (bool, error) {
-	b, err := json.Marshal(node{
-		ID:      id,
-		APIAddr: apiAddr,
-		Addr:    addr,
+	return c.InitializeLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// InitializeLeaderContext is like InitializeLeader, but the request to
+// etcd is bound to ctx.
+func (c *Client) InitializeLeaderContext(ctx context.Context, id, apiAddr, addr string) (ok bool, e error) {
+	n := c.newNode(id, apiAddr, addr)
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.initializeLeader(ctx, n)
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n)
+	})
+	e = c.do(ctx, "initialize_leader", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
+		return err
 	})
+	c.recordCAS("initialize_leader", id, ok, e)
+	return
+}
+
+// initializeLeader makes a single attempt at InitializeLeaderContext.
+func (c *Client) initializeLeader(ctx context.Context, n node) (bool, error) {
+	b, err := json.Marshal(n)
+	if err != nil {
+		return false, err
+	}
+
+	leaseID, err := c.currentLease(ctx)
 	if err != nil {
 		return false, err
 	}
 
 	kv := clientv3.NewKV(c.client)
-	resp, err := kv.Txn(context.TODO()).
+	resp, err := kv.Txn(ctx).
 		If(clientv3.Compare(clientv3.Version(c.leaderKey), "=", 0)).
 		Then(
-			clientv3.OpPut(c.leaderKey, string(b))).Commit()
+			clientv3.OpPut(c.leaderKey, string(b), clientv3.WithLease(leaseID))).Commit()
 	if err != nil {
 		return false, err
 	}
@@ -119,30 +299,623 @@ func (c *Client) InitializeLeader(id, apiAddr, addr string) (bool, error) {
 
 // SetLeader unconditionally sets the leader to the given details.
 func (c *Client) SetLeader(id, apiAddr, addr string) error {
+	return c.SetLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// SetLeaderContext is like SetLeader, but the request to etcd is bound
+// to ctx.
+func (c *Client) SetLeaderContext(ctx context.Context, id, apiAddr, addr string) error {
+	err := c.do(ctx, "set_leader", func(ctx context.Context) error {
+		return c.setLeader(ctx, id, apiAddr, addr)
+	})
+	if err == nil {
+		c.logger.Info("leader set", logging.KeyLeaderID, id)
+	}
+	return err
+}
+
+// setLeader makes a single attempt at SetLeaderContext.
+func (c *Client) setLeader(ctx context.Context, id, apiAddr, addr string) error {
 	b, err := json.Marshal(node{
-		ID:      id,
-		APIAddr: apiAddr,
-		Addr:    addr,
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	leaseID, err := c.currentLease(ctx)
+	if err != nil {
+		return err
+	}
+
+	kv := clientv3.NewKV(c.client)
+	_, err = kv.Put(ctx, c.leaderKey, string(b), clientv3.WithLease(leaseID))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// UpdateLeaderIf sets the leader to leader, but only if the leader recorded
+// in etcd still matches expected. A zero expected matches only when no
+// leader is recorded. The update is a transaction comparing the ModRevision
+// of the record which was compared, so ok is false if the record did not
+// match, or if it changed between the comparison and the update.
+func (c *Client) UpdateLeaderIf(expected, leader disco.Leader) (bool, error) {
+	return c.UpdateLeaderIfContext(context.Background(), expected, leader)
+}
+
+// UpdateLeaderIfContext is like UpdateLeaderIf, but the requests to etcd
+// are bound to ctx.
+func (c *Client) UpdateLeaderIfContext(ctx context.Context, expected, leader disco.Leader) (ok bool, e error) {
+	n := c.newNode(leader.ID, leader.APIAddr, leader.Addr)
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.updateLeaderIf(ctx, expected, n)
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n)
+	})
+	e = c.do(ctx, "update_leader_if", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
+		return err
 	})
+	c.recordCAS("update_leader_if", leader.ID, ok, e)
+	return
+}
+
+// updateLeaderIf makes a single attempt at UpdateLeaderIfContext.
+func (c *Client) updateLeaderIf(ctx context.Context, expected disco.Leader, n node) (bool, error) {
+	kv := clientv3.NewKV(c.client)
+	resp, err := kv.Get(ctx, c.leaderKey)
 	if err != nil {
+		return false, err
+	}
+	cmp := clientv3.Compare(clientv3.Version(c.leaderKey), "=", 0)
+	if len(resp.Kvs) == 0 {
+		if expected != (disco.Leader{}) {
+			return false, nil
+		}
+	} else {
+		cur := node{}
+		if err := decodeNode(resp.Kvs[0].Value, &cur); err != nil {
+			return false, err
+		}
+		if cur.leader() != expected {
+			return false, nil
+		}
+		cmp = clientv3.Compare(clientv3.ModRevision(c.leaderKey), "=", resp.Kvs[0].ModRevision)
+	}
+
+	b, err := json.Marshal(n)
+	if err != nil {
+		return false, err
+	}
+	leaseID, err := c.currentLease(ctx)
+	if err != nil {
+		return false, err
+	}
+	txnResp, err := kv.Txn(ctx).
+		If(cmp).
+		Then(clientv3.OpPut(c.leaderKey, string(b), clientv3.WithLease(leaseID))).Commit()
+	if err != nil {
+		return false, err
+	}
+	return txnResp.Succeeded, nil
+}
+
+// ResignLeader deletes the leader record, but only if it is still owned by
+// the node with the given id. The delete is a transaction comparing the
+// ModRevision of the record which was checked, so ok is false if the record
+// is owned by another node, does not exist, or changed in the meantime.
+func (c *Client) ResignLeader(id string) (bool, error) {
+	return c.ResignLeaderContext(context.Background(), id)
+}
+
+// ResignLeaderContext is like ResignLeader, but the requests to etcd are
+// bound to ctx.
+func (c *Client) ResignLeaderContext(ctx context.Context, id string) (ok bool, e error) {
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.resignLeader(ctx, id)
+	}, c.noLeaderRecord)
+	e = c.do(ctx, "resign_leader", func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		if err == nil {
+			tracing.SetCASResult(ctx, ok)
+		}
 		return err
+	})
+	c.recordCAS("resign_leader", id, ok, e)
+	return
+}
+
+// resignLeader makes a single attempt at ResignLeaderContext.
+func (c *Client) resignLeader(ctx context.Context, id string) (bool, error) {
+	kv := clientv3.NewKV(c.client)
+	resp, err := kv.Get(ctx, c.leaderKey)
+	if err != nil {
+		return false, err
+	}
+	if len(resp.Kvs) == 0 {
+		return false, nil
+	}
+	n := node{}
+	if err := decodeNode(resp.Kvs[0].Value, &n); err != nil {
+		return false, err
+	}
+	if n.ID != id {
+		return false, nil
 	}
 
+	txnResp, err := kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.ModRevision(c.leaderKey), "=", resp.Kvs[0].ModRevision)).
+		Then(clientv3.OpDelete(c.leaderKey)).Commit()
+	if err != nil {
+		return false, err
+	}
+	return txnResp.Succeeded, nil
+}
+
+// DeleteLeader unconditionally deletes the leader record. It is intended
+// for administrative recovery.
+func (c *Client) DeleteLeader() error {
+	return c.DeleteLeaderContext(context.Background())
+}
+
+// DeleteLeaderContext is like DeleteLeader, but the request to etcd is
+// bound to ctx.
+func (c *Client) DeleteLeaderContext(ctx context.Context) error {
+	return c.do(ctx, "delete_leader", func(ctx context.Context) error {
+		return c.deleteLeader(ctx)
+	})
+}
+
+// deleteLeader makes a single attempt at DeleteLeaderContext.
+func (c *Client) deleteLeader(ctx context.Context) error {
 	kv := clientv3.NewKV(c.client)
-	_, err = kv.Put(context.Background(), c.leaderKey, string(b))
+	_, err := kv.Delete(ctx, c.leaderKey)
+	return err
+}
+
+// RegisterNode records the node with the given details under the nodes
+// prefix of the key, replacing any existing record for id. If leases are
+// enabled, the record is bound to the lease, and so is removed if this
+// client stops renewing it.
+func (c *Client) RegisterNode(id, apiAddr, addr string) error {
+	return c.RegisterNodeContext(context.Background(), id, apiAddr, addr)
+}
+
+// RegisterNodeContext is like RegisterNode, but the requests to etcd are
+// bound to ctx.
+func (c *Client) RegisterNodeContext(ctx context.Context, id, apiAddr, addr string) error {
+	return c.do(ctx, "register_node", func(ctx context.Context) error {
+		return c.registerNode(ctx, id, apiAddr, addr)
+	})
+}
+
+// registerNode makes a single attempt at RegisterNodeContext.
+func (c *Client) registerNode(ctx context.Context, id, apiAddr, addr string) error {
+	b, err := json.Marshal(node{
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	leaseID, err := c.currentLease(ctx)
 	if err != nil {
 		return err
 	}
+	kv := clientv3.NewKV(c.client)
+	_, err = kv.Put(ctx, c.nodesKey+id, string(b), clientv3.WithLease(leaseID))
+	return err
+}
+
+// DeregisterNode removes the record of the node with the given id. It is
+// not an error if no such record exists.
+func (c *Client) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext is like DeregisterNode, but the request to etcd is
+// bound to ctx.
+func (c *Client) DeregisterNodeContext(ctx context.Context, id string) error {
+	return c.do(ctx, "deregister_node", func(ctx context.Context) error {
+		return c.deregisterNode(ctx, id)
+	})
+}
+
+// deregisterNode makes a single attempt at DeregisterNodeContext.
+func (c *Client) deregisterNode(ctx context.Context, id string) error {
+	kv := clientv3.NewKV(c.client)
+	_, err := kv.Delete(ctx, c.nodesKey+id)
+	return err
+}
+
+// ListNodes returns every registered node, sorted by ID.
+func (c *Client) ListNodes() ([]disco.Node, error) {
+	return c.ListNodesContext(context.Background())
+}
+
+// ListNodesContext is like ListNodes, but the request to etcd is bound
+// to ctx.
+func (c *Client) ListNodesContext(ctx context.Context) (nodes []disco.Node, e error) {
+	e = c.do(ctx, "list_nodes", func(ctx context.Context) (err error) {
+		nodes, err = c.listNodes(ctx)
+		tracing.SetRecordCount(ctx, len(nodes))
+		return err
+	})
+	return
+}
+
+// listNodes makes a single attempt at ListNodesContext.
+func (c *Client) listNodes(ctx context.Context) ([]disco.Node, error) {
+	kv := clientv3.NewKV(c.client)
+	resp, err := kv.Get(ctx, c.nodesKey, clientv3.WithPrefix(),
+		clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := make([]disco.Node, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		n := node{}
+		if err := decodeNode(kv.Value, &n); err != nil {
+			return nil, fmt.Errorf("%s: %w", kv.Key, err)
+		}
+		nodes = append(nodes, disco.Node{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr})
+	}
+	return nodes, nil
+}
+
+// EnableLeaderLease binds leader records written by subsequent calls to
+// InitializeLeader and SetLeader to an etcd lease with the given TTL, so
+// that the record is removed if this client stops renewing the lease, for
+// example because the process has crashed. Node records written by
+// RegisterNode are bound to the same lease. The lease is granted on the next
+// write, and kept alive in the background until the client is closed.
+//
+// The returned channel receives disco.ErrLeaseLost each time the lease
+// expires or is revoked, after which the next write is bound to a new lease.
+// The channel is closed when the client is closed.
+//
+// EnableLeaderLease only affects subsequent writes. In particular, calling
+// it with a TTL of 0 stops binding new records to a lease, but records
+// already written remain bound to the current lease, which is kept alive
+// until the client is closed.
+func (c *Client) EnableLeaderLease(ttl time.Duration) <-chan error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leaseTTL = ttl
+	if c.leaseLostCh == nil {
+		c.leaseLostCh = make(chan error, 1)
+		c.leaseCtx, c.leaseCancel = context.WithCancel(context.Background())
+	}
+	return c.leaseLostCh
+}
+
+// currentLease returns the lease to which records should be bound, granting
+// a new lease if necessary. If leases are not enabled, NoLease is returned.
+// The lease is granted without holding c.mu, so that a slow request to etcd
+// does not block the other operations of the client. If concurrent writes
+// both grant a lease, the first one installed is used and the others are
+// revoked.
+func (c *Client) currentLease(ctx context.Context) (clientv3.LeaseID, error) {
+	c.mu.Lock()
+	leaseTTL, leaseID := c.leaseTTL, c.leaseID
+	c.mu.Unlock()
+	if leaseTTL == 0 {
+		return clientv3.NoLease, nil
+	}
+	if leaseID != clientv3.NoLease {
+		return leaseID, nil
+	}
+
+	// etcd lease TTLs are in whole seconds.
+	ttl := (leaseTTL + time.Second - 1) / time.Second
+	resp, err := c.client.Grant(ctx, int64(ttl))
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+
+	c.mu.Lock()
+	if c.leaseID != clientv3.NoLease {
+		leaseID = c.leaseID
+		c.mu.Unlock()
+		// The lease expires with its TTL if it cannot be revoked.
+		c.client.Revoke(ctx, resp.ID)
+		return leaseID, nil
+	}
+	c.leaseID = resp.ID
+	leaseCtx := c.leaseCtx
+	c.mu.Unlock()
+
+	kch, err := c.client.KeepAlive(leaseCtx, resp.ID)
+	if err != nil {
+		c.mu.Lock()
+		if c.leaseID == resp.ID {
+			c.leaseID = clientv3.NoLease
+		}
+		c.mu.Unlock()
+		return clientv3.NoLease, err
+	}
+	go c.keepAlive(resp.ID, kch)
+	return resp.ID, nil
+}
+
+// keepAlive consumes keep alive responses for lease id until the lease is
+// lost or the client is closed.
+func (c *Client) keepAlive(id clientv3.LeaseID, kch <-chan *clientv3.LeaseKeepAliveResponse) {
+	for range kch {
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leaseID == id {
+		c.leaseID = clientv3.NoLease
+	}
+	if c.leaseCtx.Err() != nil {
+		// The client is closing.
+		return
+	}
+	select {
+	case c.leaseLostCh <- disco.ErrLeaseLost:
+	default:
+	}
+}
+
+// WatchLeader returns a channel on which changes to the leader record are
+// sent, using an etcd watch on the leader key. If a leader is recorded when
+// the watch starts, it is sent as the first event. The channel is closed
+// once ctx is done.
+func (c *Client) WatchLeader(ctx context.Context) <-chan disco.LeaderEvent {
+	ch := make(chan disco.LeaderEvent)
+	go c.watchLeader(ctx, ch, 0)
+	return ch
+}
+
+// watchLeader sends changes to the leader key on ch, starting at revision
+// rev. If rev is 0, the current record is read and the watch starts from
+// the revision after it.
+func (c *Client) watchLeader(ctx context.Context, ch chan<- disco.LeaderEvent, rev int64) {
+	defer close(ch)
+
+	// lastRev is the mod revision of the last record sent, or 0 if no
+	// record is present.
+	var lastRev int64
+	for {
+		if rev == 0 {
+			resp, err := c.client.Get(ctx, c.leaderKey)
+			if err != nil {
+				if !sendEvent(ctx, ch, disco.LeaderEvent{Err: c.wrapError("watch_leader", err)}) || !sleepContext(ctx, watchRetryInterval) {
+					return
+				}
+				continue
+			}
+			if len(resp.Kvs) == 0 {
+				if lastRev != 0 {
+					if !sendEvent(ctx, ch, disco.LeaderEvent{Deleted: true}) {
+						return
+					}
+					lastRev = 0
+				}
+			} else if kv := resp.Kvs[0]; kv.ModRevision != lastRev {
+				if !sendEvent(ctx, ch, c.leaderEvent(kv.Value)) {
+					return
+				}
+				lastRev = kv.ModRevision
+			}
+			rev = resp.Header.Revision + 1
+		}
+
+		var ok bool
+		rev, ok = c.watchFrom(ctx, ch, rev, &lastRev)
+		if !ok {
+			return
+		}
+		if rev != 0 && !sleepContext(ctx, watchRetryInterval) {
+			return
+		}
+	}
+}
+
+// watchFrom watches the leader key from revision rev, sending events on ch
+// until the watch ends. It returns the revision from which to resume, which
+// is 0 if the current record must be re-read first, and false if ctx is done.
+func (c *Client) watchFrom(ctx context.Context, ch chan<- disco.LeaderEvent, rev int64, lastRev *int64) (int64, bool) {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	for wresp := range c.client.Watch(wctx, c.leaderKey, clientv3.WithRev(rev)) {
+		if wresp.CompactRevision != 0 {
+			// Changes since rev have been compacted away, so re-read the
+			// current record and resume watching after it.
+			return 0, ctx.Err() == nil
+		}
+		if err := wresp.Err(); err != nil {
+			if !sendEvent(ctx, ch, disco.LeaderEvent{Err: c.wrapError("watch_leader", err)}) {
+				return rev, false
+			}
+			continue
+		}
+		for _, ev := range wresp.Events {
+			e := disco.LeaderEvent{Deleted: true}
+			*lastRev = 0
+			if ev.Type == clientv3.EventTypePut {
+				e = c.leaderEvent(ev.Kv.Value)
+				*lastRev = ev.Kv.ModRevision
+			}
+			if !sendEvent(ctx, ch, e) {
+				return rev, false
+			}
+			rev = ev.Kv.ModRevision + 1
+		}
+	}
+	return rev, ctx.Err() == nil
+}
+
+// do calls fn, retrying it according to the retry policy of the client and
+// within its timeout, in a span for operation op, and records the outcome.
+// The error returned by the last attempt is wrapped in a *disco.Error if its
+// cause is known.
+func (c *Client) do(ctx context.Context, op string, fn func(ctx context.Context) error) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	ctx, span := tracing.Start(ctx, c.tracer, c.String(), op, tracing.KeyKey.String(c.key))
+	start := c.clock.Now()
+	retries, err := c.retryPolicy.Do(ctx, isRetryable, fn)
+	err = c.wrapError(op, err)
+	tracing.End(span, err)
+	c.recordOp(op, start, retries, err)
+	return err
+}
+
+// recordOp records the outcome of operation op, which started at start, in
+// the statistics and metrics of the client, and logs it.
+func (c *Client) recordOp(op string, start time.Time, retries int, err error) {
+	c.stats.Record(op, start, retries, err)
+	d := c.clock.Now().Sub(start)
+	c.metrics.ObserveOp(c.String(), op, d, err)
+	if err != nil {
+		c.logger.Debug("operation failed", logging.KeyOp, op, logging.KeyRetries, retries,
+			logging.KeyDuration, d, logging.KeyError, err)
+		return
+	}
+	c.logger.Debug("operation completed", logging.KeyOp, op, logging.KeyRetries, retries,
+		logging.KeyDuration, d)
+}
+
+// recordCAS records the result of check-and-set operation op, which wrote
+// the leader record of id if ok is true, in the metrics of the client, and
+// logs it. Failed operations are recorded by recordOp.
+func (c *Client) recordCAS(op, id string, ok bool, err error) {
+	if err != nil {
+		return
+	}
+	c.metrics.ObserveCAS(c.String(), op, ok)
+	if ok {
+		c.logger.Info("compare-and-set", logging.KeyOp, op, logging.KeyLeaderID, id,
+			logging.KeyResult, logging.ResultOK)
+		return
+	}
+	c.logger.Debug("compare-and-set", logging.KeyOp, op, logging.KeyLeaderID, id,
+		logging.KeyResult, logging.ResultConflict)
+}
+
+// Stats returns diagnostics information about the client, including the
+// time of the last successful request to etcd, the last error, the count and
+// latency of each kind of operation, and the last leader read from etcd.
+func (c *Client) Stats() (map[string]interface{}, error) {
+	stats := map[string]interface{}{
+		"mode":      "etcd-kv",
+		"endpoints": c.client.Endpoints(),
+		"key":       c.key,
+	}
+	c.stats.AddTo(stats)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.lastLeader != (disco.Leader{}) {
+		stats["last_leader"] = map[string]interface{}{
+			"id":       c.lastLeader.ID,
+			"api_addr": c.lastLeader.APIAddr,
+			"addr":     c.lastLeader.Addr,
+		}
+	}
+	return stats, nil
+}
+
+// errorKind returns the sentinel error classifying err, returned by a
+// request to etcd, or nil if the cause of err is not known.
+func errorKind(err error) error {
+	for _, kind := range []error{disco.ErrCorruptRecord, disco.ErrConflict} {
+		if errors.Is(err, kind) {
+			return kind
+		}
+	}
+	if errors.Is(err, rpctypes.ErrAuthFailed) {
+		return disco.ErrPermissionDenied
+	}
+
+	var etcdErr rpctypes.EtcdError
+	code := status.Code(err)
+	if errors.As(err, &etcdErr) {
+		code = etcdErr.Code()
+	}
+	switch code {
+	case codes.NotFound:
+		return disco.ErrNotFound
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return disco.ErrPermissionDenied
+	case codes.AlreadyExists:
+		return disco.ErrConflict
+	case codes.Unavailable, codes.ResourceExhausted:
+		return disco.ErrUnavailable
+	}
+	if retry.Transient(err) {
+		return disco.ErrUnavailable
+	}
 	return nil
 }
 
+// isRetryable returns whether err, returned by a request to etcd, is likely
+// to be transient, which is the case if it indicates that etcd is
+// unreachable, overloaded or unable to serve the request, for example
+// because it has no leader.
+func isRetryable(err error) bool {
+	return errorKind(err) == disco.ErrUnavailable
+}
+
+// wrapError wraps err, returned by operation op, in a *disco.Error if its
+// cause is known.
+func (c *Client) wrapError(op string, err error) error {
+	return disco.WrapError(c.String(), op, errorKind(err), err)
+}
+
+// sawLeader records l as the last leader seen.
+func (c *Client) sawLeader(l disco.Leader) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if l != c.lastLeader {
+		c.logger.Info("leader changed", logging.KeyLeaderID, l.ID)
+	}
+	c.lastLeader = l
+}
+
 // String implements the Stringer interface.
 func (c *Client) String() string {
 	return "etcd-kv"
 }
 
-// Close closes the client.
+// Close closes the client. If leader leases are enabled, the lease is no
+// longer kept alive, and any leader record bound to it expires once its TTL
+// elapses. The same applies to leadership acquired by Campaign, which
+// should be given up with Resign first if it is not to be held until then.
 func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.election != nil {
+		c.election.stop()
+		if c.election.session != nil {
+			c.election.session.Orphan()
+		}
+		c.election = nil
+	}
+	if c.leaseCancel != nil {
+		c.leaseCancel()
+		close(c.leaseLostCh)
+		c.leaseCancel = nil
+	}
+	c.mu.Unlock()
 	return c.client.Close()
 }
 
@@ -152,11 +925,79 @@ func etcdConfigFromClientConfig(cfg *Config) *clientv3.Config {
 			Endpoints: []string{"localhost:2379"},
 		}
 	}
-	return (*clientv3.Config)(cfg)
+	return &cfg.Config
 }
 
 type node struct {
-	ID      string `json:"id,omitempty"`
-	APIAddr string `json:"api_addr,omitempty"`
-	Addr    string `json:"addr,omitempty"`
+	ID        string    `json:"id,omitempty"`
+	APIAddr   string    `json:"api_addr,omitempty"`
+	Addr      string    `json:"addr,omitempty"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (n node) leader() disco.Leader {
+	return disco.Leader{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr}
+}
+
+// newNode returns the record of a node with the given details, written now.
+func (c *Client) newNode(id, apiAddr, addr string) node {
+	return node{ID: id, APIAddr: apiAddr, Addr: addr, UpdatedAt: c.clock.Now().UTC()}
+}
+
+// isLeaderRecord returns whether the leader record is n, as written by this
+// client, comparing both the leader and the time of the write.
+func (c *Client) isLeaderRecord(ctx context.Context, n node) (bool, error) {
+	rec, ok, err := c.getLeaderRecord(ctx)
+	if err != nil || !ok {
+		return false, err
+	}
+	return rec.Leader == n.leader() && rec.UpdatedAt.Equal(n.UpdatedAt), nil
+}
+
+// noLeaderRecord returns whether there is no leader record, as left by a
+// retried ResignLeaderContext whose earlier attempt deleted it.
+func (c *Client) noLeaderRecord(ctx context.Context) (bool, error) {
+	_, ok, err := c.getLeaderRecord(ctx)
+	return !ok && err == nil, err
+}
+
+// decodeNode decodes the node record b into n.
+func decodeNode(b []byte, n *node) error {
+	if err := json.Unmarshal(b, n); err != nil {
+		return fmt.Errorf("%w: %w", disco.ErrCorruptRecord, err)
+	}
+	return nil
+}
+
+// leaderEvent returns the event for a leader record with the given value,
+// recording the leader as seen.
+func (c *Client) leaderEvent(b []byte) disco.LeaderEvent {
+	n := node{}
+	if err := decodeNode(b, &n); err != nil {
+		return disco.LeaderEvent{Err: c.wrapError("watch_leader", err)}
+	}
+	c.sawLeader(n.leader())
+	return disco.LeaderEvent{ID: n.ID, APIAddr: n.APIAddr, Addr: n.Addr}
+}
+
+// sendEvent sends e on ch, returning false if ctx is done first.
+func sendEvent(ctx context.Context, ch chan<- disco.LeaderEvent, e disco.LeaderEvent) bool {
+	select {
+	case ch <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// sleepContext pauses for d, returning false if ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
 }
@@ -1,6 +1,13 @@
 package etcd
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/expand"
+	"github.com/rqlite/rqlite-disco-clients/retry"
 	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
@@ -16,11 +23,90 @@ const (
 	"dial-keep-alive-timeout": 900000,
 	"username": "me",
 	"password": "my password",
-	"reject-old-cluster": true
+	"reject-old-cluster": true,
+	"retry": {
+		"max_attempts": 5,
+		"initial_backoff": 100000000,
+		"deadline": 10000000000
+	}
 }
 `
 )
 
-// Config stores the configuration for the etcd client.
+// Config stores the configuration for the etcd client. The fields of the
+// embedded clientv3.Config appear at the top level of the config file.
 // The full definition is available at https://pkg.go.dev/go.etcd.io/etcd/clientv3#Config
-type Config clientv3.Config
+type Config struct {
+	clientv3.Config
+
+	// PasswordFile is a file containing the password, such as a mounted
+	// secret. If it is set, the file is read when the config is loaded by
+	// NewConfigFromFile or NewConfigFromReader, and its contents, without
+	// trailing newlines, set Password, which must not be set as well.
+	PasswordFile string `json:"password_file,omitempty"`
+
+	// Retry is the policy for retrying requests to etcd which fail with
+	// transient errors. Requests are not retried if it is not set. If the
+	// check of a retried check-and-set fails, the leader record is read
+	// again, and the operation succeeds if the record is the one written by
+	// an earlier attempt whose response was lost, or, for ResignLeader, if
+	// the record is gone.
+	Retry *retry.Policy `json:"retry,omitempty"`
+}
+
+// Validate checks the values of c, returning a *disco.ConfigError listing
+// every invalid field. A nil Config is valid.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+	var v disco.Validator
+	if len(c.Endpoints) == 0 {
+		v.Add("endpoints", "must contain at least one endpoint")
+	}
+	for i, ep := range c.Endpoints {
+		if strings.TrimSpace(ep) == "" {
+			v.Add(fmt.Sprintf("endpoints[%d]", i), "must not be empty")
+		}
+	}
+	for _, d := range []struct {
+		field string
+		value time.Duration
+	}{
+		{"auto-sync-interval", c.AutoSyncInterval},
+		{"dial-timeout", c.DialTimeout},
+		{"dial-keep-alive-time", c.DialKeepAliveTime},
+		{"dial-keep-alive-timeout", c.DialKeepAliveTimeout},
+		{"backoff-wait-between", c.BackoffWaitBetween},
+	} {
+		if d.value < 0 {
+			v.Add(d.field, "must not be negative")
+		}
+	}
+	if c.BackoffJitterFraction < 0 {
+		v.Add("backoff-jitter-fraction", "must not be negative")
+	}
+	if (c.Password != "" || c.PasswordFile != "") && c.Username == "" {
+		v.Add("username", "must be set with password")
+	}
+	if c.Password != "" && c.PasswordFile != "" {
+		v.Add("password_file", "must not be set with password")
+	}
+	v.Nested("retry", c.Retry.Validate())
+	return v.Err()
+}
+
+// readFiles sets the fields of c which are read from files, returning a
+// *disco.ConfigError listing the files which cannot be read.
+func (c *Config) readFiles() error {
+	var v disco.Validator
+	if c.PasswordFile != "" {
+		password, err := expand.ReadFile(c.PasswordFile)
+		if err != nil {
+			v.Add("password_file", "%s", err)
+		} else {
+			c.Password = password
+		}
+	}
+	return v.Err()
+}
@@ -1,8 +1,43 @@
 package etcd
 
 import (
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	yamlConfig = `
+endpoints:
+  - http://1.2.3.4:8080
+  - https://5.6.7.8
+dial-timeout: 30000
+username: me
+password: ${ETCD_PASSWORD}
+reject-old-cluster: true
+retry:
+  max_attempts: 5
+  deadline: 10000000000
+`
+
+	tomlConfig = `
+endpoints = ["http://1.2.3.4:8080", "https://5.6.7.8"]
+dial-timeout = 30000
+username = "me"
+password = "${ETCD_PASSWORD}"
+reject-old-cluster = true
+
+[retry]
+max_attempts = 5
+deadline = 10000000000
+`
 )
 
 func Test_NilReaderConfig(t *testing.T) {
@@ -24,4 +59,155 @@ func Test_LoadExampleConfig(t *testing.T) {
 	if cfg == nil {
 		t.Fatalf("nil config")
 	}
+
+	if len(cfg.Endpoints) != 2 || !cfg.RejectOldCluster {
+		t.Fatalf("client config not parsed: %+v", cfg.Config)
+	}
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 5 {
+		t.Fatalf("retry policy not parsed: %+v", cfg.Retry)
+	}
+}
+
+func Test_LoadYAMLTOMLConfig(t *testing.T) {
+	t.Setenv("ETCD_PASSWORD", "my password")
+	for name, data := range map[string]string{"YAML": yamlConfig, "TOML": tomlConfig} {
+		cfg, err := NewConfigFromReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", name, err.Error())
+		}
+		checkConfig(t, name, cfg)
+	}
+}
+
+func Test_LoadConfigFileFormats(t *testing.T) {
+	t.Setenv("ETCD_PASSWORD", "my password")
+	dir := t.TempDir()
+	for file, data := range map[string]string{
+		"disco.yaml": yamlConfig,
+		"disco.toml": tomlConfig,
+		"disco.conf": yamlConfig,
+	} {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatalf("failed to write config file: %s", err.Error())
+		}
+		cfg, err := NewConfigFromFile(path)
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", file, err.Error())
+		}
+		checkConfig(t, file, cfg)
+	}
+}
+
+func checkConfig(t *testing.T, name string, cfg *Config) {
+	t.Helper()
+	if len(cfg.Endpoints) != 2 || !cfg.RejectOldCluster || cfg.DialTimeout != 30*time.Microsecond {
+		t.Fatalf("client config not parsed from %s: %+v", name, cfg.Config)
+	}
+	if cfg.Username != "me" || cfg.Password != "my password" {
+		t.Fatalf("credentials not parsed from %s: %s %s", name, cfg.Username, cfg.Password)
+	}
+	if cfg.Retry == nil || cfg.Retry.MaxAttempts != 5 || cfg.Retry.Deadline != 10*time.Second {
+		t.Fatalf("retry policy not parsed from %s: %+v", name, cfg.Retry)
+	}
+}
+
+func Test_ValidateConfig(t *testing.T) {
+	var cfg *Config
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("nil config is invalid: %s", err)
+	}
+	cfg = &Config{Config: clientv3.Config{
+		Endpoints:   []string{"localhost:2379"},
+		DialTimeout: time.Second,
+		Username:    "me",
+		Password:    "my password",
+	}}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("valid config is invalid: %s", err)
+	}
+
+	cfg = &Config{
+		Config: clientv3.Config{
+			Endpoints:            []string{"localhost:2379", " "},
+			DialTimeout:          -1,
+			DialKeepAliveTimeout: -1,
+			Password:             "my password",
+		},
+		Retry: &retry.Policy{Multiplier: 0.5},
+	}
+	exp := []string{
+		"endpoints[1]",
+		"dial-timeout",
+		"dial-keep-alive-timeout",
+		"username",
+		"retry.multiplier",
+	}
+	if got := testutil.InvalidFields(t, cfg.Validate()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_LoadConfigNoEndpoints(t *testing.T) {
+	_, err := NewConfigFromReader(strings.NewReader(`{"dial-timeout": -1}`))
+	if exp, got := []string{"endpoints", "dial-timeout"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+
+	path := filepath.Join(t.TempDir(), "disco.yaml")
+	if err := os.WriteFile(path, []byte("endpoints: []\n"), 0644); err != nil {
+		t.Fatalf("failed to write config file: %s", err.Error())
+	}
+	_, err = NewConfigFromFile(path)
+	if exp, got := []string{"endpoints"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_LoadConfigEnvPasswordEscaped(t *testing.T) {
+	password := "p\"a\\ss\nword\", \"endpoints\": [\"evil\"], \"x\": \""
+	t.Setenv("ETCD_PASSWORD", password)
+	for name, data := range map[string]string{
+		"JSON": `{"endpoints": ["localhost:2379"], "username": "me", "password": "$ETCD_PASSWORD"}`,
+		"YAML": "endpoints: [localhost:2379]\nusername: me\npassword: $ETCD_PASSWORD\n",
+	} {
+		cfg, err := NewConfigFromReader(strings.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to generate config from %s: %s", name, err.Error())
+		}
+		if !reflect.DeepEqual(cfg.Endpoints, []string{"localhost:2379"}) || cfg.Password != password {
+			t.Fatalf("password not substituted as is in %s: %v %q", name, cfg.Endpoints, cfg.Password)
+		}
+	}
+}
+
+func Test_LoadConfigPasswordFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("my password\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err.Error())
+	}
+	cfg, err := NewConfigFromReader(strings.NewReader(`
+endpoints = ["localhost:2379"]
+username = "me"
+password_file = "` + path + `"
+`))
+	if err != nil {
+		t.Fatalf("failed to generate config: %s", err.Error())
+	}
+	if exp, got := "my password", cfg.Password; exp != got {
+		t.Fatalf("wrong password, exp %q, got %q", exp, got)
+	}
+
+	_, err = NewConfigFromReader(strings.NewReader(`{"endpoints": ["localhost:2379"], "username": "me", "password_file": "/nonexistent/password"}`))
+	if exp, got := []string{"password_file"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	if !strings.Contains(err.Error(), "open /nonexistent/password: no such file or directory") {
+		t.Fatalf("error does not name missing file: %s", err)
+	}
+
+	_, err = NewConfigFromReader(strings.NewReader(`{"endpoints": ["localhost:2379"], "username": "me", "password": "secret", "password_file": "` + path + `"}`))
+	if exp, got := []string{"password_file"}, testutil.InvalidFields(t, err); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
 }
@@ -0,0 +1,318 @@
+package etcd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+const (
+	// defaultElectionTTL is the default TTL of the sessions which hold
+	// leadership acquired by Campaign.
+	defaultElectionTTL = 15 * time.Second
+)
+
+// election is the state of a single call to Campaign.
+type election struct {
+	session *concurrency.Session
+	lostCh  chan struct{}
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	stopOnce sync.Once
+}
+
+// stop cancels any requests made on behalf of the election, and closes the
+// channel returned by Campaign.
+func (e *election) stop() {
+	e.stopOnce.Do(func() {
+		e.cancel()
+		close(e.lostCh)
+	})
+}
+
+// SetElectionTTL sets the TTL of the sessions created by subsequent calls
+// to Campaign. etcd rounds it up to whole seconds.
+func (c *Client) SetElectionTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.electionTTL = ttl
+}
+
+// Campaign blocks until this client holds leadership of the key, or ctx is
+// done. It uses an etcd election under the election prefix of the key, with
+// the given details as the campaign value, held by a concurrency session
+// which is kept alive in the background. Candidates are queued in the order
+// they started campaigning, and the next candidate takes over once the
+// session of the current leader ends, for example because this process has
+// crashed. Once elected, the details are also written to the leader key,
+// bound to the lease of the session, so they are visible to GetLeader and
+// WatchLeader.
+//
+// The returned channel is closed once leadership is lost, for example
+// because the session expired, or when leadership is given up by Resign or
+// Close. A client can only run one campaign at a time.
+func (c *Client) Campaign(ctx context.Context, id, apiAddr, addr string) (lostCh <-chan struct{}, err error) {
+	start := c.clock.Now()
+	defer func() {
+		err = c.wrapError("campaign", err)
+		c.recordOp("campaign", start, 0, err)
+	}()
+
+	b, err := json.Marshal(node{
+		ID:        id,
+		APIAddr:   apiAddr,
+		Addr:      addr,
+		UpdatedAt: c.clock.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	if c.election != nil {
+		c.mu.Unlock()
+		return nil, disco.ErrCampaigning
+	}
+	e := &election{lostCh: make(chan struct{})}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	c.election = e
+	ttl := (c.electionTTL + time.Second - 1) / time.Second
+	c.mu.Unlock()
+
+	// Resign and Close stop the campaign, as well as ctx.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(e.ctx, cancel)
+	defer stop()
+
+	// The lease is granted separately so that the request is bound to ctx,
+	// while the keep alive of the session must outlive it.
+	lresp, err := c.client.Grant(ctx, int64(ttl))
+	if err != nil {
+		c.endElection(e, false)
+		return nil, contextError(ctx, err)
+	}
+	s, err := concurrency.NewSession(c.client, concurrency.WithLease(lresp.ID))
+	if err != nil {
+		c.client.Revoke(c.client.Ctx(), lresp.ID)
+		c.endElection(e, false)
+		return nil, contextError(ctx, err)
+	}
+	c.mu.Lock()
+	e.session = s
+	c.mu.Unlock()
+
+	el := concurrency.NewElection(s, c.electionKey)
+	if err := el.Campaign(ctx, string(b)); err != nil {
+		c.endElection(e, true)
+		return nil, contextError(ctx, err)
+	}
+
+	// Leadership may already have been lost, in which case the key of the
+	// campaign no longer has the revision at which it was created.
+	resp, err := c.client.Txn(ctx).
+		If(clientv3.Compare(clientv3.CreateRevision(el.Key()), "=", el.Rev())).
+		Then(clientv3.OpPut(c.leaderKey, string(b), clientv3.WithLease(s.Lease()))).
+		Commit()
+	if err != nil {
+		c.endElection(e, true)
+		return nil, contextError(ctx, err)
+	}
+	if !resp.Succeeded {
+		c.endElection(e, true)
+		return nil, disco.ErrLeaseLost
+	}
+	c.sawLeader(disco.Leader{ID: id, APIAddr: apiAddr, Addr: addr})
+	go c.monitorElection(e, el.Key(), resp.Header.Revision+1)
+	return e.lostCh, nil
+}
+
+// Resign gives up leadership acquired by Campaign by revoking the lease of
+// the session, which deletes both the key of the campaign and the leader
+// record, so the next candidate takes over immediately. If a campaign is in
+// progress, it is stopped. It is not an error to call Resign if the client
+// is not campaigning.
+func (c *Client) Resign(ctx context.Context) (err error) {
+	start := c.clock.Now()
+	defer func() {
+		err = c.wrapError("resign", err)
+		c.recordOp("resign", start, 0, err)
+	}()
+
+	c.mu.Lock()
+	e := c.election
+	c.election = nil
+	var s *concurrency.Session
+	if e != nil {
+		s = e.session
+	}
+	c.mu.Unlock()
+	if e == nil {
+		return nil
+	}
+	e.stop()
+	if s == nil {
+		// The session has not been created yet, and the campaign cleans
+		// up after itself once it sees it has been stopped.
+		return nil
+	}
+
+	s.Orphan()
+	_, err = c.client.Revoke(ctx, s.Lease())
+	if errors.Is(err, rpctypes.ErrLeaseNotFound) {
+		// The session has already expired.
+		return nil
+	}
+	return err
+}
+
+// Observe returns a channel on which the leader of the election is sent
+// each time it changes, using a watch on the election prefix of the key.
+// The leader is the campaign value of the candidate which has been queued
+// longest, so a candidate is sent once it is elected, even if it has not
+// written the leader record yet. If a leader is elected when Observe is
+// called, it is sent as the first event, and an event with Deleted set is
+// sent if there are no candidates left. The channel is closed once ctx is
+// done.
+func (c *Client) Observe(ctx context.Context) <-chan disco.LeaderEvent {
+	ch := make(chan disco.LeaderEvent)
+	go c.observe(ctx, ch)
+	return ch
+}
+
+func (c *Client) observe(ctx context.Context, ch chan<- disco.LeaderEvent) {
+	defer close(ch)
+
+	// lastRev is the mod revision of the last leader sent, or 0 if none
+	// is elected.
+	var lastRev int64
+	prefix := c.electionKey + "/"
+	for {
+		resp, err := c.client.Get(ctx, prefix, clientv3.WithFirstCreate()...)
+		if err != nil {
+			if !sendEvent(ctx, ch, disco.LeaderEvent{Err: c.wrapError("observe", err)}) || !sleepContext(ctx, watchRetryInterval) {
+				return
+			}
+			continue
+		}
+		if len(resp.Kvs) == 0 {
+			if lastRev != 0 {
+				if !sendEvent(ctx, ch, disco.LeaderEvent{Deleted: true}) {
+					return
+				}
+				lastRev = 0
+			}
+		} else if kv := resp.Kvs[0]; kv.ModRevision != lastRev {
+			if !sendEvent(ctx, ch, c.leaderEvent(kv.Value)) {
+				return
+			}
+			lastRev = kv.ModRevision
+		}
+
+		// Any change under the prefix may change the leader, so the
+		// leader is re-read once something changes.
+		if !c.waitChange(ctx, prefix, resp.Header.Revision+1) {
+			return
+		}
+	}
+}
+
+// waitChange blocks until a key under prefix changes at or after revision
+// rev, or the watch fails. It returns false if ctx is done.
+func (c *Client) waitChange(ctx context.Context, prefix string, rev int64) bool {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(ctx))
+	defer cancel()
+
+	for wresp := range c.client.Watch(wctx, prefix, clientv3.WithPrefix(), clientv3.WithRev(rev)) {
+		if wresp.CompactRevision != 0 || wresp.Err() != nil || len(wresp.Events) > 0 {
+			return true
+		}
+	}
+	return ctx.Err() == nil
+}
+
+// monitorElection watches key, the key of the campaign of e, from revision
+// rev, until leadership is lost, either because the session ended or the
+// key was deleted.
+func (c *Client) monitorElection(e *election, key string, rev int64) {
+	for {
+		lost := c.watchElection(e, key, rev)
+		if e.ctx.Err() != nil {
+			return
+		}
+		if lost {
+			c.endElection(e, true)
+			return
+		}
+
+		// The watch failed, so check the key is still present before
+		// resuming the watch.
+		resp, err := c.client.Get(e.ctx, key)
+		if err != nil {
+			if !sleepContext(e.ctx, watchRetryInterval) {
+				return
+			}
+			continue
+		}
+		if len(resp.Kvs) == 0 {
+			c.endElection(e, true)
+			return
+		}
+		rev = resp.Header.Revision + 1
+	}
+}
+
+// watchElection watches key from revision rev, returning true once the
+// session of e ends or the key is deleted, and false if the watch fails.
+func (c *Client) watchElection(e *election, key string, rev int64) bool {
+	wctx, cancel := context.WithCancel(clientv3.WithRequireLeader(e.ctx))
+	defer cancel()
+
+	wch := c.client.Watch(wctx, key, clientv3.WithRev(rev), clientv3.WithFilterPut())
+	for {
+		select {
+		case <-e.session.Done():
+			return true
+		case wresp, ok := <-wch:
+			if !ok || wresp.CompactRevision != 0 || wresp.Err() != nil {
+				return false
+			}
+			if len(wresp.Events) > 0 {
+				return true
+			}
+		}
+	}
+}
+
+// endElection stops election e, revoking the lease of its session if
+// revoke is true.
+func (c *Client) endElection(e *election, revoke bool) {
+	c.mu.Lock()
+	if c.election == e {
+		c.election = nil
+	}
+	c.mu.Unlock()
+	e.stop()
+	if e.session != nil {
+		e.session.Orphan()
+		if revoke {
+			c.client.Revoke(c.client.Ctx(), e.session.Lease())
+		}
+	}
+}
+
+// contextError returns the error of ctx if it is done, err otherwise.
+func contextError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,218 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+)
+
+func Test_Campaign(t *testing.T) {
+	key := randomString()
+	c1 := mustNewClient(t, key)
+	defer c1.Close()
+	c2 := mustNewClient(t, key)
+	defer c2.Close()
+	c3 := mustNewClient(t, key)
+	defer c3.Close()
+
+	lost1, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	mustGetLeader(t, c1, "1")
+	if _, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002"); !errors.Is(err, disco.ErrCampaigning) {
+		t.Fatalf("wrong error for second campaign, exp %v, got %v", disco.ErrCampaigning, err)
+	}
+
+	// Queued candidates must take over in the order they campaigned.
+	errCh2 := make(chan error, 1)
+	go func() {
+		_, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004")
+		errCh2 <- err
+	}()
+	time.Sleep(500 * time.Millisecond)
+	errCh3 := make(chan error, 1)
+	go func() {
+		_, err := c3.Campaign(context.Background(), "3", "http://localhost:4005", "localhost:4006")
+		errCh3 <- err
+	}()
+	time.Sleep(500 * time.Millisecond)
+	mustNotBeElected(t, errCh2)
+	mustNotBeElected(t, errCh3)
+
+	if err := c1.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	mustBeLost(t, lost1)
+	mustBeElected(t, errCh2)
+	mustGetLeader(t, c2, "2")
+	mustNotBeElected(t, errCh3)
+
+	if err := c2.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	mustBeElected(t, errCh3)
+	mustGetLeader(t, c3, "3")
+
+	if err := c3.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	if err := c3.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign when not campaigning: %s", err.Error())
+	}
+	_, _, _, ok, err := c3.GetLeader()
+	if err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("leader found after resignation")
+	}
+}
+
+func Test_CampaignSessionExpired(t *testing.T) {
+	key := randomString()
+	c1 := mustNewClient(t, key)
+	defer c1.Close()
+	c2 := mustNewClient(t, key)
+	defer c2.Close()
+
+	lost1, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	errCh2 := make(chan error, 1)
+	go func() {
+		_, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004")
+		errCh2 <- err
+	}()
+
+	// Revoking the lease simulates the session expiring.
+	c1.mu.Lock()
+	leaseID := c1.election.session.Lease()
+	c1.mu.Unlock()
+	if _, err := c1.client.Revoke(context.Background(), leaseID); err != nil {
+		t.Fatalf("failed to revoke lease: %s", err.Error())
+	}
+	mustBeLost(t, lost1)
+	mustBeElected(t, errCh2)
+	mustGetLeader(t, c2, "2")
+}
+
+func Test_CampaignContextCanceled(t *testing.T) {
+	key := randomString()
+	c1 := mustNewClient(t, key)
+	defer c1.Close()
+	c2 := mustNewClient(t, key)
+	defer c2.Close()
+
+	if _, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	_, err := c2.Campaign(ctx, "2", "http://localhost:4003", "localhost:4004")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("wrong error for canceled campaign, exp %v, got %v", context.DeadlineExceeded, err)
+	}
+	mustGetLeader(t, c1, "1")
+
+	// A canceled campaign must not prevent another one.
+	if err := c1.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	if _, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+	mustGetLeader(t, c2, "2")
+}
+
+func Test_Observe(t *testing.T) {
+	key := randomString()
+	c1 := mustNewClient(t, key)
+	defer c1.Close()
+	c2 := mustNewClient(t, key)
+	defer c2.Close()
+
+	if _, err := c1.Campaign(context.Background(), "1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("failed to campaign: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := c2.Observe(ctx)
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"})
+
+	errCh2 := make(chan error, 1)
+	go func() {
+		_, err := c2.Campaign(context.Background(), "2", "http://localhost:4003", "localhost:4004")
+		errCh2 <- err
+	}()
+	if err := c1.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	mustBeElected(t, errCh2)
+	// If c2 had not yet campaigned when c1 resigned, no leader was elected
+	// in between, which is observed.
+	exp := disco.LeaderEvent{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"}
+	select {
+	case got := <-ch:
+		if got.Deleted {
+			testutil.MustReceiveLeaderEvent(t, ch, exp)
+		} else if got != exp {
+			t.Fatalf("wrong leader event, exp %+v, got %+v", exp, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for leader event %+v", exp)
+	}
+
+	if err := c2.Resign(context.Background()); err != nil {
+		t.Fatalf("failed to resign: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{Deleted: true})
+
+	cancel()
+	testutil.MustBeClosed(t, ch)
+}
+
+func mustNewClient(t *testing.T, key string) *Client {
+	t.Helper()
+	c, err := New(key, nil)
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	return c
+}
+
+func mustBeElected(t *testing.T, errCh <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatalf("failed to campaign: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for campaign")
+	}
+}
+
+func mustNotBeElected(t *testing.T, errCh <-chan error) {
+	t.Helper()
+	select {
+	case err := <-errCh:
+		t.Fatalf("campaign returned while leadership held: %v", err)
+	default:
+	}
+}
+
+func mustBeLost(t *testing.T, ch <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for leadership loss")
+	}
+}
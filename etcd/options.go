@@ -0,0 +1,96 @@
+package etcd
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/internal/logging"
+	"github.com/rqlite/rqlite-disco-clients/metrics"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures a Client created by NewClient. Options take precedence
+// over the corresponding fields of the Config.
+type Option func(*options)
+
+type options struct {
+	logger         *slog.Logger
+	retry          *retry.Policy
+	timeout        time.Duration
+	clock          disco.Clock
+	metrics        *metrics.Registry
+	tracerProvider trace.TracerProvider
+}
+
+// newOptions returns the options set by cfg, overridden by opts.
+func newOptions(cfg *Config, opts []Option) *options {
+	o := &options{
+		logger:  logging.Discard(),
+		clock:   disco.SystemClock,
+		metrics: metrics.Default,
+	}
+	if cfg != nil {
+		o.retry = cfg.Retry
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithLogger sets the logger to which the client logs its operations.
+// Nothing is logged if it is not set. The logger of the underlying etcd
+// client is set through the Logger field of the embedded clientv3.Config.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *options) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// WithRetry sets the policy for retrying requests to etcd which fail with
+// transient errors. A nil policy disables retries.
+func WithRetry(p *retry.Policy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
+
+// WithTimeout bounds each operation of the client, including its retries,
+// by d. It does not apply to Campaign, which blocks until leadership is
+// acquired, nor to watches.
+func WithTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.timeout = d
+	}
+}
+
+// WithClock sets the clock used for the timestamps of the records written
+// by the client, and for its statistics.
+func WithClock(clock disco.Clock) Option {
+	return func(o *options) {
+		if clock != nil {
+			o.clock = clock
+		}
+	}
+}
+
+// WithMetrics sets the registry to which the client records its metrics,
+// instead of metrics.Default. A nil registry disables metrics.
+func WithMetrics(r *metrics.Registry) Option {
+	return func(o *options) {
+		o.metrics = r
+	}
+}
+
+// WithTracerProvider sets the provider of the tracer with which the client
+// creates a span for each operation. The global TracerProvider is used if it
+// is not set.
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(o *options) {
+		o.tracerProvider = tp
+	}
+}
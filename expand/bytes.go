@@ -1,16 +1,10 @@
 package expand
 
-import "os"
-
+// ExpandEnvBytes replaces references to environment variables in input
+// with their values, as described in the package documentation. input is
+// treated as plain text, so values are substituted without escaping. Use
+// ExpandEnvJSON for JSON text. Problems, such as a required variable which
+// is not set, are ignored; use an Expander to report them.
 func ExpandEnvBytes(input []byte) []byte {
-	// Convert byte slice to string
-	inputStr := string(input)
-
-	// Run os.ExpandEnv on the string
-	expandedStr := os.ExpandEnv(inputStr)
-
-	// Convert the expanded string back to a byte slice
-	expandedBytes := []byte(expandedStr)
-
-	return expandedBytes
+	return []byte(NewExpander().Expand(string(input)))
 }
@@ -0,0 +1,248 @@
+// Package expand expands references to environment variables in the
+// configuration files of the clients.
+//
+// A reference is $VAR or ${VAR}, where VAR is made of letters, digits and
+// underscores. The following forms are supported as well, as in shells:
+//
+//	${VAR:-default}  default if VAR is not set or empty
+//	${VAR:?message}  an error reporting message if VAR is not set or empty
+//	${file:path}     the contents of the file at path, such as a mounted
+//	                 secret, without trailing newlines
+//	$$               a literal $
+//
+// The default, the message and the path may themselves contain references.
+// A $ which does not start a reference is left as it is.
+package expand
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Option configures an Expander.
+type Option func(*Expander)
+
+// Strict makes an Expander report every variable which is referenced
+// without a default, but is not set.
+func Strict() Option {
+	return func(e *Expander) {
+		e.strict = true
+	}
+}
+
+// UndefinedError is reported in strict mode when variables which are
+// referenced without a default are not set.
+type UndefinedError struct {
+	// Names are the names of the variables, in the order they were first
+	// referenced.
+	Names []string
+}
+
+// Error implements the error interface.
+func (e *UndefinedError) Error() string {
+	if len(e.Names) == 1 {
+		return "undefined environment variable: " + e.Names[0]
+	}
+	return "undefined environment variables: " + strings.Join(e.Names, ", ")
+}
+
+// Expander expands references to environment variables, collecting the
+// problems it finds, such as a variable required by ${VAR:?message} which
+// is not set, so that they can all be reported at once by Err.
+type Expander struct {
+	strict    bool
+	undefined []string
+	seen      map[string]bool
+	errs      []error
+}
+
+// NewExpander returns an Expander configured by opts.
+func NewExpander(opts ...Option) *Expander {
+	e := &Expander{}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e
+}
+
+// Expand returns s with the references it contains expanded. A variable
+// which is not set expands to an empty string.
+func (e *Expander) Expand(s string) string {
+	return e.expand(s, nil)
+}
+
+// Err returns the problems found by the calls to the Expander, or nil if
+// there were none.
+func (e *Expander) Err() error {
+	errs := e.errs
+	if len(e.undefined) > 0 {
+		errs = append(errs[:len(errs):len(errs)], &UndefinedError{Names: e.undefined})
+	}
+	return errors.Join(errs...)
+}
+
+// quoter quotes the values substituted into the text of a document, such
+// as a JSON string.
+type quoter interface {
+	// quote returns v quoted for the document.
+	quote(v string) string
+
+	// unquote returns the value of s, text taken from the document.
+	unquote(s string) (string, error)
+}
+
+// expand returns s with the references it contains expanded. If q is not
+// nil, it quotes the values of the variables, but not the text of defaults,
+// which is taken from s.
+func (e *Expander) expand(s string, q quoter) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		switch c := s[i+1]; {
+		case c == '$':
+			b.WriteByte('$')
+			i++
+		case c == '{':
+			end := closingBrace(s, i+2)
+			if end < 0 {
+				e.errs = append(e.errs, fmt.Errorf("unterminated reference %s", s[i:]))
+				b.WriteString(s[i:])
+				return b.String()
+			}
+			b.WriteString(e.reference(s[i+2:end], q))
+			i = end
+		case isNameChar(c):
+			j := i + 1
+			for j < len(s) && isNameChar(s[j]) {
+				j++
+			}
+			b.WriteString(e.lookup(s[i+1:j], q))
+			i = j - 1
+		default:
+			b.WriteByte('$')
+		}
+	}
+	return b.String()
+}
+
+// reference returns the expansion of ref, the text between the braces of
+// a reference.
+func (e *Expander) reference(ref string, q quoter) string {
+	n := 0
+	for n < len(ref) && isNameChar(ref[n]) {
+		n++
+	}
+	name, op := ref[:n], ref[n:]
+	switch {
+	case name == "":
+		e.errs = append(e.errs, fmt.Errorf("bad substitution ${%s}", ref))
+		return ""
+	case op == "":
+		return e.lookup(name, q)
+	case strings.HasPrefix(op, ":-"):
+		if v := os.Getenv(name); v != "" {
+			return quoteValue(v, q)
+		}
+		return e.expand(op[2:], q)
+	case strings.HasPrefix(op, ":?"):
+		if v := os.Getenv(name); v != "" {
+			return quoteValue(v, q)
+		}
+		msg := e.expand(op[2:], q)
+		if msg == "" {
+			msg = "not set or empty"
+		}
+		e.errs = append(e.errs, fmt.Errorf("%s: %s", name, msg))
+		return ""
+	case name == "file" && strings.HasPrefix(op, ":"):
+		path := e.expand(op[1:], q)
+		if q != nil {
+			unquoted, err := q.unquote(path)
+			if err != nil {
+				e.errs = append(e.errs, fmt.Errorf("${file:%s}: %w", path, err))
+				return ""
+			}
+			path = unquoted
+		}
+		return e.readFile(path, q)
+	}
+	e.errs = append(e.errs, fmt.Errorf("bad substitution ${%s}", ref))
+	return ""
+}
+
+// lookup returns the value of the variable name, recording it as undefined
+// if it is not set and the Expander is strict.
+func (e *Expander) lookup(name string, q quoter) string {
+	v, ok := os.LookupEnv(name)
+	if !ok && e.strict && !e.seen[name] {
+		if e.seen == nil {
+			e.seen = make(map[string]bool)
+		}
+		e.seen[name] = true
+		e.undefined = append(e.undefined, name)
+	}
+	return quoteValue(v, q)
+}
+
+// readFile returns the contents of the file at path, referred to by a
+// ${file:path} reference, without trailing newlines.
+func (e *Expander) readFile(path string, q quoter) string {
+	if path == "" {
+		e.errs = append(e.errs, errors.New("empty path in ${file:} reference"))
+		return ""
+	}
+	v, err := ReadFile(path)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("${file:%s}: %w", path, err))
+		return ""
+	}
+	return quoteValue(v, q)
+}
+
+// ReadFile returns the contents of the file at path, such as a mounted
+// secret, without trailing newlines.
+func ReadFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+func quoteValue(v string, q quoter) string {
+	if q == nil {
+		return v
+	}
+	return q.quote(v)
+}
+
+// closingBrace returns the index of the brace closing a reference whose
+// text starts at index start of s, skipping nested references, or -1 if
+// there is none.
+func closingBrace(s string, start int) int {
+	depth := 0
+	for i := start; i < len(s); i++ {
+		switch {
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '$':
+			i++
+		case s[i] == '$' && i+1 < len(s) && s[i+1] == '{':
+			depth++
+			i++
+		case s[i] == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+func isNameChar(c byte) bool {
+	return c == '_' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
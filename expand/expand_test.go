@@ -0,0 +1,160 @@
+package expand
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Expander(t *testing.T) {
+	t.Setenv("NAME", "rqlite")
+	t.Setenv("EMPTY", "")
+	t.Setenv("PORT", "4001")
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Simple reference", "$NAME:${PORT}", "rqlite:4001"},
+		{"Default for unset variable", "${UNSET:-localhost}:${PORT:-8500}", "localhost:4001"},
+		{"Default for empty variable", "${EMPTY:-default}", "default"},
+		{"Empty default", "[${UNSET:-}]", "[]"},
+		{"Nested default", "${UNSET:-${UNSET2:-$NAME}.local}", "rqlite.local"},
+		{"Escaped dollar", "pa$$word $${NAME} $$$NAME", "pa$word ${NAME} $rqlite"},
+		{"Dollar not starting reference", "a $ b $! $-1 $", "a $ b $! $-1 $"},
+		{"Required variable set", "${NAME:?must be set}", "rqlite"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewExpander(Strict())
+			if got := e.Expand(tc.input); got != tc.expected {
+				t.Fatalf("Expected %q, but got %q", tc.expected, got)
+			}
+			if err := e.Err(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		})
+	}
+}
+
+func Test_ExpanderErrors(t *testing.T) {
+	t.Setenv("EMPTY", "")
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{"Required variable unset", "${UNSET:?CONSUL_ADDRESS typo?}", "UNSET: CONSUL_ADDRESS typo?"},
+		{"Required variable empty", "${EMPTY:?}", "EMPTY: not set or empty"},
+		{"Bad name", "${A-B}", "bad substitution ${A-B}"},
+		{"Empty name", "${}", "bad substitution ${}"},
+		{"Unterminated reference", "x ${NAME", "unterminated reference ${NAME"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			e := NewExpander()
+			e.Expand(tc.input)
+			err := e.Err()
+			if err == nil || err.Error() != tc.expected {
+				t.Fatalf("Expected error %q, but got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func Test_ExpanderStrict(t *testing.T) {
+	t.Setenv("NAME", "rqlite")
+
+	e := NewExpander()
+	if got := e.Expand("$UNSET1"); got != "" {
+		t.Fatalf("Expected empty string, but got %q", got)
+	}
+	if err := e.Err(); err != nil {
+		t.Fatalf("unexpected error when not strict: %s", err)
+	}
+
+	e = NewExpander(Strict())
+	e.Expand("$UNSET1 ${UNSET2} $NAME ${UNSET3:-default}")
+	e.Expand("${UNSET2}:${UNSET4} ${UNSET5:?required}")
+	err := e.Err()
+	var undefinedErr *UndefinedError
+	if !errors.As(err, &undefinedErr) {
+		t.Fatalf("expected *UndefinedError, got %v", err)
+	}
+	if exp := []string{"UNSET1", "UNSET2", "UNSET4"}; !reflect.DeepEqual(exp, undefinedErr.Names) {
+		t.Fatalf("wrong undefined variables, exp %v, got %v", exp, undefinedErr.Names)
+	}
+	exp := "UNSET5: required\nundefined environment variables: UNSET1, UNSET2, UNSET4"
+	if err.Error() != exp {
+		t.Fatalf("wrong error, exp %q, got %q", exp, err.Error())
+	}
+}
+
+func Test_ExpanderJSON(t *testing.T) {
+	t.Setenv("PASSWORD", `a"b`)
+	e := NewExpander(Strict())
+	got := e.ExpandJSON([]byte(`{"password": "${PASSWORD}", "token": "${UNSET:-\"x\"}", "price": "$$5"}`))
+	if exp := `{"password": "a\"b", "token": "\"x\"", "price": "$5"}`; string(got) != exp {
+		t.Fatalf("Expected %s, but got %s", exp, got)
+	}
+	if err := e.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func Test_ExpanderFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("s3cr\"et\r\n\n"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	t.Setenv("SECRETS_DIR", dir)
+
+	e := NewExpander(Strict())
+	if got, exp := e.Expand("${file:"+path+"}"), `s3cr"et`; got != exp {
+		t.Fatalf("Expected %q, but got %q", exp, got)
+	}
+	if got, exp := e.Expand("Bearer ${file:${SECRETS_DIR}/token}"), `Bearer s3cr"et`; got != exp {
+		t.Fatalf("Expected %q, but got %q", exp, got)
+	}
+	if got, exp := string(e.ExpandJSON([]byte(`{"token": "${file:$SECRETS_DIR/token}"}`))), `{"token": "s3cr\"et"}`; got != exp {
+		t.Fatalf("Expected %s, but got %s", exp, got)
+	}
+	// Paths in JSON strings are unescaped before the file is read.
+	if err := os.WriteFile(filepath.Join(dir, `to"ken\`), []byte("t0ken"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if got, exp := string(e.ExpandJSON([]byte(`{"token": "${file:$SECRETS_DIR/to\"ken\\}"}`))), `{"token": "t0ken"}`; got != exp {
+		t.Fatalf("Expected %s, but got %s", exp, got)
+	}
+	// A variable named file can still have a default.
+	if got, exp := e.Expand("${file:-default}"), "default"; got != exp {
+		t.Fatalf("Expected %q, but got %q", exp, got)
+	}
+	if err := e.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+}
+
+func Test_ExpanderFileErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	e := NewExpander()
+	if got := e.Expand("${file:" + missing + "}${file:}"); got != "" {
+		t.Fatalf("Expected empty string, but got %q", got)
+	}
+	err := e.Err()
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected fs.ErrNotExist, got %v", err)
+	}
+	for _, exp := range []string{"${file:" + missing + "}: open " + missing, "empty path in ${file:} reference"} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Fatalf("error does not contain %q: %s", exp, err)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package expand
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// ExpandEnvJSON is like ExpandEnvBytes, but for JSON text. Values
+// substituted inside JSON strings are escaped, so that a value containing a
+// quote, a backslash or a newline cannot end the string, or otherwise change
+// the structure of the document. Outside strings, the value of a reference
+// is substituted as it is if it is a JSON number, boolean or null, so that
+// "port": $PORT can set a number, and as a JSON string otherwise.
+// Inside strings, the path of a ${file:path} reference is escaped like the
+// rest of the string, so a path containing a quote or a backslash is written
+// with \" or \\.
+func ExpandEnvJSON(input []byte) []byte {
+	return NewExpander().ExpandJSON(input)
+}
+
+// ExpandJSON is like Expand, but for JSON text, escaping values as
+// described for ExpandEnvJSON.
+func (e *Expander) ExpandJSON(input []byte) []byte {
+	var out bytes.Buffer
+	inString := false
+	start := 0
+	for i := 0; i < len(input); i++ {
+		switch input[i] {
+		case '\\':
+			if inString {
+				// Skip the escaped character, which may be a quote.
+				i++
+			}
+		case '"':
+			e.writeExpanded(&out, input[start:i], inString)
+			out.WriteByte('"')
+			inString = !inString
+			start = i + 1
+		}
+	}
+	if start < len(input) {
+		e.writeExpanded(&out, input[start:], inString)
+	}
+	return out.Bytes()
+}
+
+// writeExpanded writes b to out, with its references expanded. If inString
+// is true, the values of variables are escaped as the contents of a JSON
+// string, and otherwise each reference is replaced by a JSON value, as
+// returned by jsonValue.
+func (e *Expander) writeExpanded(out *bytes.Buffer, b []byte, inString bool) {
+	if inString {
+		out.WriteString(e.expand(string(b), jsonQuoter{}))
+		return
+	}
+	s := string(b)
+	for i := 0; i < len(s); i++ {
+		if s[i] != '$' || i+1 == len(s) {
+			out.WriteByte(s[i])
+			continue
+		}
+		end := i + 2
+		switch c := s[i+1]; {
+		case c == '{':
+			if end = closingBrace(s, i+2) + 1; end == 0 {
+				end = len(s)
+			}
+		case isNameChar(c):
+			for end < len(s) && isNameChar(s[end]) {
+				end++
+			}
+		}
+		out.WriteString(jsonValue(e.Expand(s[i:end])))
+		i = end - 1
+	}
+}
+
+// jsonValue returns v as a JSON value: v itself if it is a number, a
+// boolean or null, and a string holding v otherwise.
+func jsonValue(v string) string {
+	var x interface{}
+	if err := json.Unmarshal([]byte(v), &x); err == nil {
+		switch x.(type) {
+		case float64, bool, nil:
+			return v
+		}
+	}
+	return `"` + jsonQuoter{}.quote(v) + `"`
+}
+
+// jsonQuoter quotes values as the contents of JSON strings.
+type jsonQuoter struct{}
+
+// quote returns s escaped as the contents of a JSON string, without the
+// enclosing quotes.
+func (jsonQuoter) quote(s string) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(s); err != nil {
+		return ""
+	}
+	// Strip the quotes, and the newline written by Encode.
+	return string(b.Bytes()[1 : b.Len()-2])
+}
+
+// unquote returns the value of s, the contents of a JSON string without the
+// enclosing quotes, such as the path of a ${file:path} reference.
+func (jsonQuoter) unquote(s string) (string, error) {
+	var v string
+	if err := json.Unmarshal([]byte(`"`+s+`"`), &v); err != nil {
+		return "", err
+	}
+	return v, nil
+}
@@ -0,0 +1,97 @@
+package expand
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_ExpandEnvJSON(t *testing.T) {
+	testCases := []struct {
+		name     string
+		env      map[string]string
+		input    string
+		expected string
+	}{
+		{
+			name:     "Simple value in string",
+			env:      map[string]string{"ADDRESS": "localhost:8500"},
+			input:    `{"address": "${ADDRESS}"}`,
+			expected: `{"address": "localhost:8500"}`,
+		},
+		{
+			name:     "Value with quote and backslash",
+			env:      map[string]string{"PASSWORD": `pa"ss\word`},
+			input:    `{"password": "$PASSWORD"}`,
+			expected: `{"password": "pa\"ss\\word"}`,
+		},
+		{
+			name:     "Value injecting a field",
+			env:      map[string]string{"USERNAME": `me", "password": "stolen`},
+			input:    `{"username": "${USERNAME}"}`,
+			expected: `{"username": "me\", \"password\": \"stolen"}`,
+		},
+		{
+			name:     "Value with newline and HTML",
+			env:      map[string]string{"PEM": "-----BEGIN CERTIFICATE-----\n<&>\n"},
+			input:    `{"cert": "${PEM}"}`,
+			expected: `{"cert": "-----BEGIN CERTIFICATE-----\n<&>\n"}`,
+		},
+		{
+			name:     "Value outside string",
+			env:      map[string]string{"PORT": "4002"},
+			input:    `{"port": $PORT, "name": "rqlite"}`,
+			expected: `{"port": 4002, "name": "rqlite"}`,
+		},
+		{
+			name:     "Value outside string injecting a field",
+			env:      map[string]string{"PORT": `4002, "name": "evil"`},
+			input:    `{"name": "rqlite", "port": $PORT}`,
+			expected: `{"name": "rqlite", "port": "4002, \"name\": \"evil\""}`,
+		},
+		{
+			name:     "Values outside string",
+			env:      map[string]string{"ENABLED": "true", "RATIO": "1.5e3", "NAME": "rqlite"},
+			input:    `{"a": ${ENABLED}, "b": [$RATIO, null, $NAME], "c": ${UNSET:-5}}`,
+			expected: `{"a": true, "b": [1.5e3, null, "rqlite"], "c": 5}`,
+		},
+		{
+			name:     "Escaped quote in string",
+			env:      map[string]string{"NAME": "rqlite"},
+			input:    `{"name": "\"$NAME\" $NAME"}`,
+			expected: `{"name": "\"rqlite\" rqlite"}`,
+		},
+		{
+			name:     "Unterminated string",
+			env:      map[string]string{"NAME": `a"b`},
+			input:    `{"name": "$NAME`,
+			expected: `{"name": "a\"b`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			for key, value := range tc.env {
+				t.Setenv(key, value)
+			}
+
+			output := ExpandEnvJSON([]byte(tc.input))
+			if string(output) != tc.expected {
+				t.Fatalf("Expected %s, but got %s", tc.expected, output)
+			}
+		})
+	}
+}
+
+func Test_ExpandEnvJSONDecode(t *testing.T) {
+	value := "line 1\nline \"2\"\t\\ é"
+	t.Setenv("VALUE", value)
+
+	var m map[string]string
+	if err := json.Unmarshal(ExpandEnvJSON([]byte(`{"a": "$VALUE", "b": "x${VALUE}y"}`)), &m); err != nil {
+		t.Fatalf("failed to decode expanded JSON: %s", err)
+	}
+	if exp := map[string]string{"a": value, "b": "x" + value + "y"}; !reflect.DeepEqual(exp, m) {
+		t.Fatalf("wrong values, exp %q, got %q", exp, m)
+	}
+}
@@ -0,0 +1,295 @@
+// Package config decodes the configuration files of the clients, which may
+// be written in JSON, YAML or TOML. Whatever the format, the names of the
+// fields are those of the JSON tags of the configuration types, and
+// environment variables are expanded before the data is decoded.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/rqlite/rqlite-disco-clients/expand"
+	"gopkg.in/yaml.v3"
+)
+
+// Format is the format of configuration data.
+type Format string
+
+// Formats of configuration data.
+const (
+	JSON Format = "json"
+	YAML Format = "yaml"
+	TOML Format = "toml"
+)
+
+// Detect returns the format of data, read from the file at path. The format
+// is given by the extension of path if it is known, such as ".yaml", and
+// is sniffed from the content of data otherwise. path may be empty if the
+// data was not read from a file.
+func Detect(path string, data []byte) Format {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return JSON
+	case ".yaml", ".yml":
+		return YAML
+	case ".toml":
+		return TOML
+	}
+	return sniff(data)
+}
+
+// sniff returns the format of data, guessed from its first line which is
+// neither blank nor a comment. JSON objects start with a brace, and TOML
+// documents with a table header or a key followed by an equals sign.
+// Anything else is taken to be YAML.
+func sniff(data []byte) Format {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	if t := bytes.TrimSpace(data); len(t) == 0 || t[0] == '{' {
+		return JSON
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return TOML
+		}
+		if i := strings.IndexAny(line, "=:"); i > 0 && line[i] == '=' {
+			return TOML
+		}
+		return YAML
+	}
+	return YAML
+}
+
+// Unmarshal decodes data, read from the file at path, into v in the format
+// returned by Detect, expanding the environment variables it refers to as
+// configured by opts. path may be empty if the data was not read from a
+// file. Problems found by the expansion, such as undefined variables in
+// strict mode, are all returned at once.
+//
+// Variables are expanded without changing the structure of the document,
+// whatever their values. In JSON, values substituted inside strings are
+// escaped, and references outside strings are replaced by their value if it
+// is a number, a boolean or null, and by a string holding it otherwise. In
+// YAML and TOML, only the values of scalars are expanded, after the document
+// is parsed. Unquoted YAML scalars are typed after expansion, so that
+// "port: $PORT" sets a number. TOML does not allow unquoted
+// references, so those found outside strings, as in "port = $PORT", are
+// replaced before the document is parsed: by their value if it is a number,
+// a boolean or a date, and by a string holding it otherwise.
+func Unmarshal(path string, data []byte, v interface{}, opts ...expand.Option) error {
+	return decode(Detect(path, data), data, v, expand.NewExpander(opts...))
+}
+
+// Decode decodes data in format f into v. YAML and TOML documents are
+// converted to JSON before they are decoded, so that v is decoded according
+// to its JSON tags in every format.
+func Decode(f Format, data []byte, v interface{}) error {
+	return decode(f, data, v, nil)
+}
+
+// decode decodes data in format f into v, expanding environment variables
+// with e unless it is nil.
+func decode(f Format, data []byte, v interface{}, e *expand.Expander) error {
+	var m map[string]interface{}
+	switch f {
+	case JSON:
+		if e != nil {
+			data = e.ExpandJSON(data)
+			if err := e.Err(); err != nil {
+				return err
+			}
+		}
+		return json.Unmarshal(data, v)
+	case YAML:
+		var doc yaml.Node
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return fmt.Errorf("invalid YAML: %w", err)
+		}
+		if e != nil {
+			expandYAML(e, &doc)
+			if err := e.Err(); err != nil {
+				return err
+			}
+		}
+		if err := doc.Decode(&m); err != nil {
+			return fmt.Errorf("invalid YAML: %w", err)
+		}
+	case TOML:
+		if e != nil {
+			data = expandBareTOML(e, data)
+		}
+		if _, err := toml.Decode(string(data), &m); err != nil {
+			return fmt.Errorf("invalid TOML: %w", err)
+		}
+		if e != nil {
+			expandValue(e, m)
+			if err := e.Err(); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported configuration format %q", f)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", strings.ToUpper(string(f)), err)
+	}
+	return json.Unmarshal(b, v)
+}
+
+// expandYAML expands the environment variables in the scalars of the
+// document rooted at n. The tags of unquoted scalars which are expanded are
+// cleared, so that they are resolved again from their expanded values.
+// Aliases are not followed, so each scalar is expanded once.
+func expandYAML(e *expand.Expander, n *yaml.Node) {
+	if n.Kind == yaml.ScalarNode && strings.Contains(n.Value, "$") {
+		n.Value = e.Expand(n.Value)
+		if n.Style == 0 {
+			n.Tag = ""
+		}
+	}
+	for _, c := range n.Content {
+		expandYAML(e, c)
+	}
+}
+
+// expandValue returns v, a value decoded from TOML, with the environment
+// variables in its strings expanded.
+func expandValue(e *expand.Expander, v interface{}) interface{} {
+	switch v := v.(type) {
+	case string:
+		return e.Expand(v)
+	case map[string]interface{}:
+		for k, c := range v {
+			v[k] = expandValue(e, c)
+		}
+	case []map[string]interface{}:
+		for _, c := range v {
+			expandValue(e, c)
+		}
+	case []interface{}:
+		for i, c := range v {
+			v[i] = expandValue(e, c)
+		}
+	}
+	return v
+}
+
+// expandBareTOML returns data with the references to environment variables
+// which are neither in strings nor in comments replaced by TOML values, as
+// returned by tomlValue.
+func expandBareTOML(e *expand.Expander, data []byte) []byte {
+	if !bytes.Contains(data, []byte("$")) {
+		return data
+	}
+	var b bytes.Buffer
+	for i := 0; i < len(data); {
+		var n int
+		switch data[i] {
+		case '#':
+			if n = bytes.IndexByte(data[i:], '\n'); n < 0 {
+				n = len(data) - i
+			}
+		case '"', '\'':
+			n = tomlStringLen(data[i:])
+		case '$':
+			n = referenceLen(data[i:])
+			b.WriteString(tomlValue(e.Expand(string(data[i : i+n]))))
+			i += n
+			continue
+		default:
+			n = 1
+		}
+		b.Write(data[i : i+n])
+		i += n
+	}
+	return b.Bytes()
+}
+
+// tomlStringLen returns the length of the TOML string, basic or literal,
+// single-line or multi-line, at the start of s. An unterminated string
+// runs to the end of its line, or of s if it is multi-line.
+func tomlStringLen(s []byte) int {
+	q := s[0]
+	if len(s) >= 3 && s[1] == q && s[2] == q {
+		for i := 3; i < len(s); i++ {
+			switch {
+			case q == '"' && s[i] == '\\':
+				i++
+			case bytes.HasPrefix(s[i:], []byte{q, q, q}):
+				// Up to two quotes may precede the closing delimiter.
+				i += 3
+				for k := 0; k < 2 && i < len(s) && s[i] == q; k++ {
+					i++
+				}
+				return i
+			}
+		}
+		return len(s)
+	}
+	for i := 1; i < len(s); i++ {
+		switch {
+		case q == '"' && s[i] == '\\':
+			i++
+		case s[i] == q:
+			return i + 1
+		case s[i] == '\n':
+			return i
+		}
+	}
+	return len(s)
+}
+
+// referenceLen returns the length of the reference to an environment
+// variable at the start of s, such as $VAR or ${VAR:-default}. A reference
+// with an unterminated brace runs to the end of its line.
+func referenceLen(s []byte) int {
+	if len(s) > 1 && s[1] == '{' {
+		depth := 0
+		for i := 2; i < len(s); i++ {
+			switch s[i] {
+			case '{':
+				depth++
+			case '}':
+				if depth == 0 {
+					return i + 1
+				}
+				depth--
+			case '\n':
+				return i
+			}
+		}
+		return len(s)
+	}
+	i := 1
+	for i < len(s) && (s[i] == '_' || '0' <= s[i] && s[i] <= '9' || 'a' <= s[i] && s[i] <= 'z' || 'A' <= s[i] && s[i] <= 'Z') {
+		i++
+	}
+	return i
+}
+
+// tomlValue returns v as a TOML value: v itself if it is a number, a
+// boolean or a date, and a basic string holding v otherwise. The $ in the
+// string are doubled, so that the expansion of the strings of the parsed
+// document leaves v unchanged.
+func tomlValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, "#\r\n") {
+		var m map[string]interface{}
+		if _, err := toml.Decode("v = "+v, &m); err == nil && len(m) == 1 {
+			switch m["v"].(type) {
+			case int64, float64, bool, time.Time:
+				return v
+			}
+		}
+	}
+	b, _ := json.Marshal(strings.ReplaceAll(v, "$", "$$"))
+	return string(b)
+}
@@ -0,0 +1,251 @@
+package config
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/expand"
+)
+
+type testConfig struct {
+	Name      string        `json:"name,omitempty"`
+	Endpoints []string      `json:"endpoints,omitempty"`
+	Timeout   time.Duration `json:"dial-timeout,omitempty"`
+	Retry     *testRetry    `json:"retry,omitempty"`
+}
+
+type testRetry struct {
+	MaxAttempts int     `json:"max_attempts,omitempty"`
+	Multiplier  float64 `json:"multiplier,omitempty"`
+}
+
+func Test_Detect(t *testing.T) {
+	testCases := []struct {
+		name string
+		path string
+		data string
+		exp  Format
+	}{
+		{"json extension", "disco.json", "name: rqlite", JSON},
+		{"yaml extension", "disco.yaml", `{"name": "rqlite"}`, YAML},
+		{"yml extension", "/etc/DISCO.YML", "", YAML},
+		{"toml extension", "disco.toml", "", TOML},
+		{"json content", "", "\n  {\"name\": \"rqlite\"}", JSON},
+		{"json content with BOM", "", "\xef\xbb\xbf{}", JSON},
+		{"empty content", "", " \n", JSON},
+		{"yaml content", "", "# comment\nname: rqlite\n", YAML},
+		{"yaml document start", "", "---\nname: rqlite\n", YAML},
+		{"yaml value with equals sign", "", "name: a=b\n", YAML},
+		{"toml key", "disco.conf", "# comment\n\nname = \"rqlite\"\n", TOML},
+		{"toml key with colon in value", "", "address = \"localhost:8500\"\n", TOML},
+		{"toml table", "", "[retry]\nmax_attempts = 3\n", TOML},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Detect(tc.path, []byte(tc.data)); got != tc.exp {
+				t.Fatalf("wrong format, exp %s, got %s", tc.exp, got)
+			}
+		})
+	}
+}
+
+func Test_Decode(t *testing.T) {
+	exp := testConfig{
+		Name:      "rqlite",
+		Endpoints: []string{"localhost:2379", "localhost:2380"},
+		Timeout:   5000000000,
+		Retry:     &testRetry{MaxAttempts: 3, Multiplier: 1.5},
+	}
+	testCases := []struct {
+		format Format
+		data   string
+	}{
+		{JSON, `{
+	"name": "rqlite",
+	"endpoints": ["localhost:2379", "localhost:2380"],
+	"dial-timeout": 5000000000,
+	"retry": {"max_attempts": 3, "multiplier": 1.5}
+}`},
+		{YAML, `
+name: rqlite
+endpoints:
+  - localhost:2379
+  - localhost:2380
+dial-timeout: 5000000000
+retry:
+  max_attempts: 3
+  multiplier: 1.5
+`},
+		{TOML, `
+name = "rqlite"
+endpoints = ["localhost:2379", "localhost:2380"]
+dial-timeout = 5000000000
+
+[retry]
+max_attempts = 3
+multiplier = 1.5
+`},
+	}
+	for _, tc := range testCases {
+		t.Run(string(tc.format), func(t *testing.T) {
+			var cfg testConfig
+			if err := Decode(tc.format, []byte(tc.data), &cfg); err != nil {
+				t.Fatalf("failed to decode: %s", err)
+			}
+			if !reflect.DeepEqual(cfg, exp) {
+				t.Fatalf("wrong config, exp %+v, got %+v", exp, cfg)
+			}
+		})
+	}
+}
+
+func Test_DecodeInvalid(t *testing.T) {
+	for f, data := range map[Format]string{
+		JSON: `{"name": }`,
+		YAML: "name: [rqlite\n",
+		TOML: "name = \n",
+	} {
+		var cfg testConfig
+		if err := Decode(f, []byte(data), &cfg); err == nil {
+			t.Fatalf("expected error decoding invalid %s", f)
+		}
+	}
+
+	var cfg testConfig
+	if err := Decode(YAML, []byte("name: [rqlite]\n"), &cfg); err == nil {
+		t.Fatalf("expected error decoding YAML of wrong type")
+	}
+	if err := Decode("ini", []byte("name=rqlite"), &cfg); err == nil {
+		t.Fatalf("expected error decoding unsupported format")
+	}
+}
+
+func Test_UnmarshalEnv(t *testing.T) {
+	t.Setenv("DISCO_NAME", "rqlite")
+	var cfg testConfig
+	if err := Unmarshal("disco.yaml", []byte("name: ${DISCO_NAME}\n"), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if exp, got := "rqlite", cfg.Name; exp != got {
+		t.Fatalf("wrong name, exp %s, got %s", exp, got)
+	}
+}
+
+func Test_UnmarshalEnvUnsafe(t *testing.T) {
+	name := `rq"lite', \ # "endpoints": ["evil"]` + "\n[retry]\nmax_attempts: 9\nmax_attempts = 9"
+	t.Setenv("DISCO_NAME", name)
+	t.Setenv("DISCO_TIMEOUT", "5000000000")
+	t.Setenv("DISCO_MULTIPLIER", "1.5")
+	exp := testConfig{
+		Name:    name,
+		Timeout: 5 * time.Second,
+		Retry:   &testRetry{Multiplier: 1.5},
+	}
+	for path, data := range map[string]string{
+		"disco.json": `{"name": "$DISCO_NAME", "dial-timeout": $DISCO_TIMEOUT, "retry": {"multiplier": ${DISCO_MULTIPLIER}}}`,
+		"disco.yaml": "name: $DISCO_NAME\ndial-timeout: ${DISCO_TIMEOUT}\nretry:\n  multiplier: $DISCO_MULTIPLIER\n",
+		"disco.toml": "name = $DISCO_NAME\ndial-timeout = $DISCO_TIMEOUT\n[retry]\nmultiplier = ${DISCO_MULTIPLIER}\n",
+	} {
+		var cfg testConfig
+		if err := Unmarshal(path, []byte(data), &cfg); err != nil {
+			t.Fatalf("failed to unmarshal %s: %s", path, err)
+		}
+		if !reflect.DeepEqual(cfg, exp) {
+			t.Fatalf("wrong config from %s, exp %+v, got %+v", path, exp, cfg)
+		}
+	}
+}
+
+func Test_UnmarshalEnvYAMLTypes(t *testing.T) {
+	t.Setenv("DISCO_NAME", "4002")
+	t.Setenv("DISCO_ATTEMPTS", "3")
+	var cfg testConfig
+	data := "name: \"$DISCO_NAME\"\nretry:\n  max_attempts: $DISCO_ATTEMPTS\n"
+	if err := Unmarshal("", []byte(data), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	exp := testConfig{Name: "4002", Retry: &testRetry{MaxAttempts: 3}}
+	if cfg.Name != exp.Name || cfg.Retry == nil || *cfg.Retry != *exp.Retry {
+		t.Fatalf("wrong config, exp %+v, got %+v", exp, cfg)
+	}
+
+	// An unquoted number is not a string.
+	if err := Unmarshal("", []byte("name: $DISCO_NAME\n"), &cfg); err == nil {
+		t.Fatalf("expected error decoding number into string")
+	}
+}
+
+func Test_UnmarshalEnvYAMLAlias(t *testing.T) {
+	t.Setenv("DISCO_NAME", "$HOME")
+	var cfg testConfig
+	data := "name: &name $DISCO_NAME\nendpoints: [*name]\n"
+	if err := Unmarshal("", []byte(data), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	if cfg.Name != "$HOME" || !reflect.DeepEqual(cfg.Endpoints, []string{"$HOME"}) {
+		t.Fatalf("value expanded more than once: %+v", cfg)
+	}
+}
+
+func Test_UnmarshalEnvTOMLTypes(t *testing.T) {
+	t.Setenv("DISCO_NAME", "4002")
+	t.Setenv("DISCO_ATTEMPTS", "3")
+	t.Setenv("DISCO_HOST", "$HOME # not a comment")
+	var cfg testConfig
+	data := `# $DISCO_ATTEMPTS
+name = "$DISCO_NAME" # $DISCO_HOST
+endpoints = [$DISCO_HOST, '$DISCO_HOST', """$$DISCO_HOST"""]
+[retry]
+max_attempts = $DISCO_ATTEMPTS
+multiplier = ${DISCO_MULTIPLIER:-1.5}
+`
+	if err := Unmarshal("disco.toml", []byte(data), &cfg); err != nil {
+		t.Fatalf("failed to unmarshal: %s", err)
+	}
+	exp := testConfig{
+		Name:      "4002",
+		Endpoints: []string{"$HOME # not a comment", "$HOME # not a comment", "$DISCO_HOST"},
+		Retry:     &testRetry{MaxAttempts: 3, Multiplier: 1.5},
+	}
+	if !reflect.DeepEqual(cfg, exp) {
+		t.Fatalf("wrong config, exp %+v, got %+v", exp, cfg)
+	}
+
+	// An unquoted number is not a string.
+	if err := Unmarshal("disco.toml", []byte("name = $DISCO_NAME\n"), &cfg); err == nil {
+		t.Fatalf("expected error decoding number into string")
+	}
+}
+
+func Test_UnmarshalStrict(t *testing.T) {
+	t.Setenv("DISCO_NAME", "rqlite")
+	for path, data := range map[string]string{
+		"disco.json": `{"name": "${DISCO_NAME}", "endpoints": ["$DISCO_HOST1", "${DISCO_HOST2}"], "retry": {"max_attempts": ${DISCO_ATTEMPTS:-3}}}`,
+		"disco.yaml": "name: $DISCO_NAME\nendpoints: [$DISCO_HOST1, $DISCO_HOST2]\nretry:\n  max_attempts: ${DISCO_ATTEMPTS:-3}\n",
+		"disco.toml": "name = \"$DISCO_NAME\"\nendpoints = [$DISCO_HOST1, \"${DISCO_HOST2}\"]\n[retry]\nmax_attempts = ${DISCO_ATTEMPTS:-3}\n",
+	} {
+		var cfg testConfig
+		err := Unmarshal(path, []byte(data), &cfg, expand.Strict())
+		var undefinedErr *expand.UndefinedError
+		if !errors.As(err, &undefinedErr) {
+			t.Fatalf("expected *expand.UndefinedError from %s, got %v", path, err)
+		}
+		if exp := []string{"DISCO_HOST1", "DISCO_HOST2"}; !reflect.DeepEqual(exp, undefinedErr.Names) {
+			t.Fatalf("wrong undefined variables from %s, exp %v, got %v", path, exp, undefinedErr.Names)
+		}
+
+		if err := Unmarshal(path, []byte(data), &cfg); err != nil {
+			t.Fatalf("failed to unmarshal %s when not strict: %s", path, err)
+		}
+	}
+}
+
+func Test_UnmarshalRequired(t *testing.T) {
+	var cfg testConfig
+	err := Unmarshal("disco.yaml", []byte("name: ${DISCO_NAME:?the name of the cluster}\n"), &cfg)
+	if err == nil || err.Error() != "DISCO_NAME: the name of the cluster" {
+		t.Fatalf("expected error for required variable, got %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+// Package logging provides the default loggers of the clients, and the keys
+// of the attributes of the records they log, so that records from every
+// backend can be filtered and aggregated in the same way.
+//
+// Clients log changes of state, such as newly resolved addresses or a
+// successful compare-and-set, at level Info. Each operation, including its
+// retries and any error it returns to the caller, is logged at level Debug.
+package logging
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Keys of the attributes of the records logged by the clients.
+const (
+	KeyBackend   = "backend"
+	KeyOp        = "op"
+	KeyKey       = "key"
+	KeyName      = "name"
+	KeyAddresses = "addresses"
+	KeyCount     = "count"
+	KeyRetries   = "retries"
+	KeyDuration  = "duration"
+	KeyResult    = "result"
+	KeyLeaderID  = "leader_id"
+	KeyError     = "error"
+)
+
+// Values of the KeyResult attribute.
+const (
+	ResultOK       = "ok"
+	ResultConflict = "conflict"
+	ResultError    = "error"
+)
+
+// Discard returns a logger which discards all records.
+func Discard() *slog.Logger {
+	return slog.New(discardHandler{})
+}
+
+// Std returns a logger which writes records at level Info and above to
+// stderr, through a *log.Logger with the given prefix. Each record is
+// written as its message followed by its attributes as key=value pairs.
+func Std(prefix string) *slog.Logger {
+	return newStd(os.Stderr, prefix, log.LstdFlags)
+}
+
+func newStd(w io.Writer, prefix string, flag int) *slog.Logger {
+	return slog.New(&stdHandler{
+		logger: log.New(w, prefix, flag),
+		level:  slog.LevelInfo,
+	})
+}
+
+type discardHandler struct{}
+
+func (discardHandler) Enabled(context.Context, slog.Level) bool  { return false }
+func (discardHandler) Handle(context.Context, slog.Record) error { return nil }
+func (h discardHandler) WithAttrs([]slog.Attr) slog.Handler      { return h }
+func (h discardHandler) WithGroup(string) slog.Handler           { return h }
+
+// stdHandler is a slog.Handler writing records through a *log.Logger.
+type stdHandler struct {
+	logger *log.Logger
+	level  slog.Level
+	attrs  string // Attributes added by WithAttrs, already formatted.
+	group  string // Prefix of the keys of attributes, ending in a dot.
+}
+
+func (h *stdHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *stdHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(r.Message)
+	b.WriteString(h.attrs)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	h.logger.Print(b.String())
+	return nil
+}
+
+func (h *stdHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	var b strings.Builder
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2 := *h
+	h2.attrs += b.String()
+	return &h2
+}
+
+func (h *stdHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+// appendAttr appends a to b as " key=value", prefixing the key with group.
+// The attributes of a group are appended individually.
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+		return
+	}
+	b.WriteString(" ")
+	b.WriteString(group)
+	b.WriteString(a.Key)
+	b.WriteString("=")
+	b.WriteString(a.Value.String())
+}
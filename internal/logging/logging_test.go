@@ -0,0 +1,39 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func Test_Std(t *testing.T) {
+	var buf bytes.Buffer
+	logger := newStd(&buf, "[test] ", 0).With(KeyBackend, "dns")
+
+	logger.Debug("lookup", KeyCount, 1)
+	if buf.Len() != 0 {
+		t.Fatalf("debug record written: %q", buf.String())
+	}
+
+	logger.Info("resolved addresses", KeyAddresses, []string{"1.1.1.1:4001", "2.2.2.2:4001"})
+	exp := "[test] resolved addresses backend=dns addresses=[1.1.1.1:4001 2.2.2.2:4001]\n"
+	if got := buf.String(); got != exp {
+		t.Fatalf("wrong output, exp %q, got %q", exp, got)
+	}
+
+	buf.Reset()
+	logger.WithGroup("g").Warn("message", slog.Group("sub", KeyName, "x"), KeyCount, 2)
+	exp = "[test] message backend=dns g.sub.name=x g.count=2\n"
+	if got := buf.String(); got != exp {
+		t.Fatalf("wrong output, exp %q, got %q", exp, got)
+	}
+}
+
+func Test_Discard(t *testing.T) {
+	logger := Discard()
+	if logger.Enabled(context.Background(), slog.LevelError) {
+		t.Fatalf("discard logger is enabled")
+	}
+	logger.With(KeyBackend, "dns").Error("message")
+}
@@ -0,0 +1,99 @@
+// Package opstats records the outcome of the operations performed by a
+// client, so that they can be reported by its Stats method.
+package opstats
+
+import (
+	"sync"
+	"time"
+)
+
+// op holds the statistics of a single kind of operation.
+type op struct {
+	count   uint64
+	errors  uint64
+	retries uint64
+	total   time.Duration
+	max     time.Duration
+}
+
+// Recorder records the number, latency, retries and errors of operations,
+// by name.
+// The zero value is ready to use, and a Recorder is safe for concurrent
+// use.
+type Recorder struct {
+	// Now returns the current time. If it is nil, time.Now is used.
+	Now func() time.Time
+
+	mu          sync.Mutex
+	ops         map[string]*op
+	lastContact time.Time
+	lastError   error
+}
+
+// Record records an operation called name, which started at start, was
+// retried retries times, and failed with err if it is not nil.
+func (r *Recorder) Record(name string, start time.Time, retries int, err error) {
+	now := r.now()
+	d := now.Sub(start)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.ops == nil {
+		r.ops = make(map[string]*op)
+	}
+	o, ok := r.ops[name]
+	if !ok {
+		o = &op{}
+		r.ops[name] = o
+	}
+	o.count++
+	o.retries += uint64(retries)
+	o.total += d
+	if d > o.max {
+		o.max = d
+	}
+	if err != nil {
+		o.errors++
+		r.lastError = err
+		return
+	}
+	r.lastContact = now
+}
+
+func (r *Recorder) now() time.Time {
+	if r.Now != nil {
+		return r.Now()
+	}
+	return time.Now()
+}
+
+// AddTo adds the recorded statistics to stats. The time of the last
+// successful operation is added as last_contact, the last error as
+// last_error, and the count, number of errors and retries, and average and
+// maximum latency of each kind of operation under operations. The latency
+// of an operation includes its retries.
+func (r *Recorder) AddTo(stats map[string]interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.lastError != nil {
+		stats["last_error"] = r.lastError.Error()
+	}
+	if !r.lastContact.IsZero() {
+		stats["last_contact"] = r.lastContact
+	}
+	if len(r.ops) == 0 {
+		return
+	}
+	ops := make(map[string]interface{}, len(r.ops))
+	for name, o := range r.ops {
+		ops[name] = map[string]interface{}{
+			"count":       o.count,
+			"errors":      o.errors,
+			"retries":     o.retries,
+			"latency_avg": (o.total / time.Duration(o.count)).String(),
+			"latency_max": o.max.String(),
+		}
+	}
+	stats["operations"] = ops
+}
@@ -0,0 +1,78 @@
+package opstats
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_RecorderEmpty(t *testing.T) {
+	var r Recorder
+	stats := map[string]interface{}{}
+	r.AddTo(stats)
+	if len(stats) != 0 {
+		t.Fatalf("expected no stats, got %v", stats)
+	}
+}
+
+func Test_Recorder(t *testing.T) {
+	var r Recorder
+	r.Record("get", time.Now().Add(-2*time.Second), 2, nil)
+	r.Record("get", time.Now(), 0, errors.New("boom"))
+	r.Record("set", time.Now(), 0, nil)
+
+	stats := map[string]interface{}{}
+	r.AddTo(stats)
+	if got, exp := stats["last_error"], "boom"; got != exp {
+		t.Fatalf("wrong last_error, exp %v, got %v", exp, got)
+	}
+	if _, ok := stats["last_contact"].(time.Time); !ok {
+		t.Fatalf("last_contact missing or wrong type: %v", stats["last_contact"])
+	}
+
+	ops := stats["operations"].(map[string]interface{})
+	get := ops["get"].(map[string]interface{})
+	if got, exp := get["count"], uint64(2); got != exp {
+		t.Fatalf("wrong count, exp %v, got %v", exp, got)
+	}
+	if got, exp := get["errors"], uint64(1); got != exp {
+		t.Fatalf("wrong errors, exp %v, got %v", exp, got)
+	}
+	if got, exp := get["retries"], uint64(2); got != exp {
+		t.Fatalf("wrong retries, exp %v, got %v", exp, got)
+	}
+	max, err := time.ParseDuration(get["latency_max"].(string))
+	if err != nil {
+		t.Fatalf("failed to parse latency_max: %s", err.Error())
+	}
+	if max < 2*time.Second {
+		t.Fatalf("wrong latency_max, exp at least 2s, got %s", max)
+	}
+	avg, err := time.ParseDuration(get["latency_avg"].(string))
+	if err != nil {
+		t.Fatalf("failed to parse latency_avg: %s", err.Error())
+	}
+	if avg < time.Second || avg >= max {
+		t.Fatalf("wrong latency_avg, got %s", avg)
+	}
+	set := ops["set"].(map[string]interface{})
+	if got, exp := set["errors"], uint64(0); got != exp {
+		t.Fatalf("wrong errors, exp %v, got %v", exp, got)
+	}
+}
+
+func Test_RecorderNow(t *testing.T) {
+	now := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	r := Recorder{Now: func() time.Time { return now }}
+	r.Record("get", now.Add(-time.Second), 0, nil)
+
+	stats := map[string]interface{}{}
+	r.AddTo(stats)
+	if got := stats["last_contact"]; got != now {
+		t.Fatalf("wrong last_contact, exp %v, got %v", now, got)
+	}
+	get := stats["operations"].(map[string]interface{})["get"].(map[string]interface{})
+	if got, exp := get["latency_max"], "1s"; got != exp {
+		t.Fatalf("wrong latency_max, exp %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,79 @@
+// Package testutil provides helpers shared by the tests of the clients.
+package testutil
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// FixedClock is a disco.Clock which always returns the same time.
+type FixedClock time.Time
+
+// Now implements disco.Clock.
+func (c FixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+// MustReceiveLeaderEvent fails the test unless exp is the next event
+// received from ch within 5 seconds.
+func MustReceiveLeaderEvent(t *testing.T, ch <-chan disco.LeaderEvent, exp disco.LeaderEvent) {
+	t.Helper()
+	select {
+	case got, ok := <-ch:
+		if !ok {
+			t.Fatalf("watch channel closed unexpectedly")
+		}
+		if got != exp {
+			t.Fatalf("wrong leader event, exp %+v, got %+v", exp, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for leader event %+v", exp)
+	}
+}
+
+// MustBeClosed fails the test unless ch is closed within 5 seconds, without
+// any further event being received from it.
+func MustBeClosed(t *testing.T, ch <-chan disco.LeaderEvent) {
+	t.Helper()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("watch channel not closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for watch channel to close")
+	}
+}
+
+// InvalidFields returns the fields listed by err, which must be a
+// *disco.ConfigError.
+func InvalidFields(t *testing.T, err error) []string {
+	t.Helper()
+	var cfgErr *disco.ConfigError
+	if !errors.As(err, &cfgErr) {
+		t.Fatalf("expected *disco.ConfigError, got %v", err)
+	}
+	if !errors.Is(err, disco.ErrInvalidConfig) {
+		t.Fatalf("error does not match disco.ErrInvalidConfig: %s", err)
+	}
+	var fields []string
+	for _, f := range cfgErr.Fields {
+		fields = append(fields, f.Field)
+	}
+	return fields
+}
+
+// SpanAttr returns the value of attribute key of span, and whether it is set.
+func SpanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
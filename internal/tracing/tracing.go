@@ -0,0 +1,96 @@
+// Package tracing creates the OpenTelemetry spans of the operations of the
+// clients, with attributes shared by every backend.
+//
+// Each span is named after the backend and the operation, such as
+// "consul-kv.get_leader" or "dns.lookup", and has a result attribute. The
+// result is "ok" unless the operation sets another, such as "conflict" for
+// a check-and-set which was rejected, or fails, when it is "error" and the
+// error is recorded on the span.
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the name of the tracer of the clients.
+const TracerName = "github.com/rqlite/rqlite-disco-clients"
+
+// Keys of the attributes of the spans.
+const (
+	KeyBackend     = attribute.Key("disco.backend")
+	KeyKey         = attribute.Key("disco.key")
+	KeyName        = attribute.Key("disco.name")
+	KeyRecordCount = attribute.Key("disco.record_count")
+	KeyResult      = attribute.Key("disco.result")
+)
+
+// Values of the KeyResult attribute.
+const (
+	ResultOK       = "ok"
+	ResultConflict = "conflict"
+	ResultNotFound = "not_found"
+	ResultError    = "error"
+)
+
+// Tracer returns the tracer of the clients from tp, or from the global
+// TracerProvider if tp is nil.
+func Tracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(TracerName)
+}
+
+// Start starts a span for operation op of backend, with the given
+// attributes. The span is a child of any span in ctx, and is in the
+// returned context.
+func Start(ctx context.Context, tracer trace.Tracer, backend, op string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	attrs = append([]attribute.KeyValue{KeyBackend.String(backend), KeyResult.String(ResultOK)}, attrs...)
+	return tracer.Start(ctx, backend+"."+op, trace.WithAttributes(attrs...))
+}
+
+// End ends span, recording err if it is not nil.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(KeyResult.String(ResultError))
+	}
+	span.End()
+}
+
+// SetResult sets the result of the span in ctx.
+func SetResult(ctx context.Context, result string) {
+	trace.SpanFromContext(ctx).SetAttributes(KeyResult.String(result))
+}
+
+// SetRecordCount sets the number of records, such as leader records or
+// addresses, read by the operation of the span in ctx.
+func SetRecordCount(ctx context.Context, n int) {
+	trace.SpanFromContext(ctx).SetAttributes(KeyRecordCount.Int(n))
+}
+
+// SetCASResult sets the result of the check-and-set operation of the span
+// in ctx, which succeeded if ok is true, and was rejected because of a
+// conflicting write otherwise.
+func SetCASResult(ctx context.Context, ok bool) {
+	if !ok {
+		SetResult(ctx, ResultConflict)
+	}
+}
+
+// SetFound sets the record count of the span in ctx to 1 if a single record
+// was found, or to 0 and its result to "not_found" otherwise.
+func SetFound(ctx context.Context, found bool) {
+	if !found {
+		SetRecordCount(ctx, 0)
+		SetResult(ctx, ResultNotFound)
+		return
+	}
+	SetRecordCount(ctx, 1)
+}
@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func Test_Span(t *testing.T) {
+	sr := tracetest.NewSpanRecorder()
+	tracer := Tracer(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+
+	ctx, span := Start(context.Background(), tracer, "etcd-kv", "update_leader_if", KeyKey.String("rqlite"))
+	SetCASResult(ctx, false)
+	End(span, nil)
+	ctx, span = Start(context.Background(), tracer, "etcd-kv", "get_leader")
+	SetFound(ctx, true)
+	End(span, nil)
+	_, span = Start(context.Background(), tracer, "etcd-kv", "list_nodes")
+	End(span, errors.New("boom"))
+
+	spans := sr.Ended()
+	if exp, got := 3, len(spans); exp != got {
+		t.Fatalf("wrong number of spans, exp %d, got %d", exp, got)
+	}
+	testCases := []struct {
+		name   string
+		attrs  map[attribute.Key]attribute.Value
+		status codes.Code
+	}{
+		{
+			name: "etcd-kv.update_leader_if",
+			attrs: map[attribute.Key]attribute.Value{
+				KeyBackend: attribute.StringValue("etcd-kv"),
+				KeyKey:     attribute.StringValue("rqlite"),
+				KeyResult:  attribute.StringValue(ResultConflict),
+			},
+		},
+		{
+			name: "etcd-kv.get_leader",
+			attrs: map[attribute.Key]attribute.Value{
+				KeyRecordCount: attribute.IntValue(1),
+				KeyResult:      attribute.StringValue(ResultOK),
+			},
+		},
+		{
+			name: "etcd-kv.list_nodes",
+			attrs: map[attribute.Key]attribute.Value{
+				KeyResult: attribute.StringValue(ResultError),
+			},
+			status: codes.Error,
+		},
+	}
+	for i, tc := range testCases {
+		span := spans[i]
+		if span.Name() != tc.name {
+			t.Fatalf("wrong name of span %d, exp %s, got %s", i, tc.name, span.Name())
+		}
+		if span.Status().Code != tc.status {
+			t.Fatalf("wrong status of span %s, exp %v, got %v", tc.name, tc.status, span.Status().Code)
+		}
+		attrs := make(map[attribute.Key]attribute.Value)
+		for _, kv := range span.Attributes() {
+			attrs[kv.Key] = kv.Value
+		}
+		for k, v := range tc.attrs {
+			if attrs[k] != v {
+				t.Fatalf("wrong value of %s of span %s, exp %v, got %v", k, tc.name, v.Emit(), attrs[k].Emit())
+			}
+		}
+	}
+}
@@ -0,0 +1,567 @@
+// Package memkv provides an in-memory leader store, which implements the
+// same semantics as the Consul and etcd clients without requiring a
+// running server. It is intended for tests and single-process use.
+package memkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
+// Operation names passed to a Store's failure function.
+const (
+	OpGet        = "get"
+	OpInitialize = "initialize"
+	OpSet        = "set"
+	OpUpdate     = "update"
+	OpResign     = "resign"
+	OpDelete     = "delete"
+	OpRegister   = "register"
+	OpDeregister = "deregister"
+	OpList       = "list"
+)
+
+var (
+	// ErrClosed is returned when an operation is attempted on a closed client.
+	ErrClosed = errors.New("client is closed")
+)
+
+// Store is an in-memory key-value store. Clients which share a Store
+// see each other's writes, just as clients connected to the same Consul
+// or etcd cluster would.
+type Store struct {
+	mu      sync.Mutex
+	entries map[string]entry
+	rev     uint64
+	changed chan struct{}
+
+	cfgMu       sync.RWMutex
+	latency     time.Duration
+	failFn      func(op, key string) error
+	failAfterFn func(op, key string) error
+}
+
+// NewStore returns an empty Store.
+func NewStore() *Store {
+	return &Store{
+		entries: make(map[string]entry),
+		changed: make(chan struct{}),
+	}
+}
+
+// SetLatency sets the delay applied to every operation against the store,
+// simulating the round-trip to a remote server.
+func (s *Store) SetLatency(d time.Duration) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.latency = d
+}
+
+// SetFailFn sets a function which is called before every operation against
+// the store. If it returns a non-nil error the operation fails with that
+// error, and the store is not modified. A nil fn disables failure injection.
+func (s *Store) SetFailFn(fn func(op, key string) error) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.failFn = fn
+}
+
+// SetFailAfterFn sets a function which is called after every operation
+// against the store has been applied. If it returns a non-nil error the
+// operation fails with that error, although the store was modified,
+// simulating a response lost on its way back from a remote server. A nil fn
+// disables failure injection.
+func (s *Store) SetFailAfterFn(fn func(op, key string) error) {
+	s.cfgMu.Lock()
+	defer s.cfgMu.Unlock()
+	s.failAfterFn = fn
+}
+
+// put stores n under key, written at updatedAt, and wakes any watchers. The
+// caller must hold s.mu.
+func (s *Store) put(key string, n disco.Node, updatedAt time.Time) {
+	s.rev++
+	e, ok := s.entries[key]
+	if !ok {
+		e = entry{createRev: s.rev}
+	}
+	e.Node = n
+	e.modRev = s.rev
+	e.version++
+	e.updatedAt = updatedAt
+	s.entries[key] = e
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// delete removes key, and wakes any watchers. The caller must hold s.mu.
+func (s *Store) delete(key string) {
+	s.rev++
+	delete(s.entries, key)
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// before applies any simulated latency and injected failure for op.
+func (s *Store) before(ctx context.Context, op, key string) error {
+	s.cfgMu.RLock()
+	latency, failFn := s.latency, s.failFn
+	s.cfgMu.RUnlock()
+
+	if latency > 0 {
+		timer := time.NewTimer(latency)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if failFn != nil {
+		return failFn(op, key)
+	}
+	return nil
+}
+
+// after applies any failure injected once op has been applied.
+func (s *Store) after(op, key string) error {
+	s.cfgMu.RLock()
+	failAfterFn := s.failAfterFn
+	s.cfgMu.RUnlock()
+
+	if failAfterFn != nil {
+		return failAfterFn(op, key)
+	}
+	return nil
+}
+
+// Client represents an in-memory leader store client.
+type Client struct {
+	store     *Store
+	key       string
+	leaderKey string
+	nodesKey  string
+
+	retryPolicy *retry.Policy
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+var (
+	_ disco.LeaderStore   = (*Client)(nil)
+	_ disco.LeaderWatcher = (*Client)(nil)
+	_ disco.NodeRegistry  = (*Client)(nil)
+)
+
+// New returns a client which records the leader under key in store,
+// configured by opts. If store is nil, a new Store is created for the
+// exclusive use of the client.
+func New(key string, store *Store, opts ...Option) *Client {
+	if store == nil {
+		store = NewStore()
+	}
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return &Client{
+		store:       store,
+		key:         key,
+		leaderKey:   fmt.Sprintf("%s/leader", key),
+		nodesKey:    fmt.Sprintf("%s/nodes/", key),
+		retryPolicy: o.retry,
+	}
+}
+
+// registryStore is the Store shared by the clients built by
+// disco.NewFromConfig, so that clients built for the same key see each
+// other's writes, as if they were connected to the same cluster.
+var registryStore = NewStore()
+
+func init() {
+	disco.Register("memkv", newFromConfig)
+}
+
+// newFromConfig is the disco.Factory of memkv clients. memkv has no
+// configuration, so r is ignored, as are the options other than the key.
+func newFromConfig(r io.Reader, o *disco.Options) (disco.Client, error) {
+	return New(o.Key, registryStore), nil
+}
+
+// GetLeader returns the leader as recorded in the store. If a leader exists,
+// ok will be set to true, false otherwise.
+func (c *Client) GetLeader() (id string, apiAddr string, addr string, ok bool, e error) {
+	return c.GetLeaderContext(context.Background())
+}
+
+// GetLeaderContext is like GetLeader, but the operation is abandoned if ctx
+// is done before it completes.
+func (c *Client) GetLeaderContext(ctx context.Context) (id string, apiAddr string, addr string, ok bool, e error) {
+	rec, ok, err := c.GetLeaderRecordContext(ctx)
+	if err != nil || !ok {
+		return "", "", "", false, err
+	}
+	return rec.ID, rec.APIAddr, rec.Addr, true, nil
+}
+
+// GetLeaderRecord returns the leader as recorded in the store, along with
+// the metadata of the record. The revisions are those of the store, which
+// is incremented by every write, and the ModRevision is also used as the
+// Term. If a leader exists, ok will be set to true, false otherwise.
+func (c *Client) GetLeaderRecord() (rec disco.LeaderRecord, ok bool, e error) {
+	return c.GetLeaderRecordContext(context.Background())
+}
+
+// GetLeaderRecordContext is like GetLeaderRecord, but the operation is
+// abandoned if ctx is done before it completes.
+func (c *Client) GetLeaderRecordContext(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
+	e = c.do(ctx, func(ctx context.Context) (err error) {
+		rec, ok, err = c.getLeaderRecord(ctx)
+		return err
+	})
+	return
+}
+
+// getLeaderRecord makes a single attempt at GetLeaderRecordContext.
+func (c *Client) getLeaderRecord(ctx context.Context) (rec disco.LeaderRecord, ok bool, e error) {
+	ok, e = c.apply(ctx, OpGet, c.leaderKey, func() bool {
+		ent, ok := c.store.entries[c.leaderKey]
+		if !ok {
+			return false
+		}
+		rec = disco.LeaderRecord{
+			Leader:         disco.Leader(ent.Node),
+			CreateRevision: ent.createRev,
+			ModRevision:    ent.modRev,
+			Version:        ent.version,
+			Term:           ent.modRev,
+			UpdatedAt:      ent.updatedAt,
+		}
+		return true
+	})
+	if !ok {
+		rec = disco.LeaderRecord{}
+	}
+	return
+}
+
+// InitializeLeader sets the leader to the given details, but only if no leader
+// has already been set. This operation is a check-and-set type operation. If
+// initialization succeeds, ok is set to true.
+func (c *Client) InitializeLeader(id, apiAddr, addr string) (bool, error) {
+	return c.InitializeLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// InitializeLeaderContext is like InitializeLeader, but the operation is
+// abandoned if ctx is done before it completes.
+func (c *Client) InitializeLeaderContext(ctx context.Context, id, apiAddr, addr string) (ok bool, e error) {
+	n, now := disco.Node{ID: id, APIAddr: apiAddr, Addr: addr}, time.Now().UTC()
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.apply(ctx, OpInitialize, c.leaderKey, func() bool {
+			if _, ok := c.store.entries[c.leaderKey]; ok {
+				return false
+			}
+			c.store.put(c.leaderKey, n, now)
+			return true
+		})
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n, now)
+	})
+	e = c.do(ctx, func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		return err
+	})
+	return
+}
+
+// SetLeader unconditionally sets the leader to the given details.
+func (c *Client) SetLeader(id, apiAddr, addr string) error {
+	return c.SetLeaderContext(context.Background(), id, apiAddr, addr)
+}
+
+// SetLeaderContext is like SetLeader, but the operation is abandoned if ctx
+// is done before it completes.
+func (c *Client) SetLeaderContext(ctx context.Context, id, apiAddr, addr string) error {
+	n, now := disco.Node{ID: id, APIAddr: apiAddr, Addr: addr}, time.Now().UTC()
+	return c.do(ctx, func(ctx context.Context) error {
+		_, err := c.apply(ctx, OpSet, c.leaderKey, func() bool {
+			c.store.put(c.leaderKey, n, now)
+			return true
+		})
+		return err
+	})
+}
+
+// UpdateLeaderIf sets the leader to leader, but only if the recorded leader
+// still matches expected. A zero expected matches only when no leader is
+// recorded. ok is false if the record did not match.
+func (c *Client) UpdateLeaderIf(expected, leader disco.Leader) (bool, error) {
+	return c.UpdateLeaderIfContext(context.Background(), expected, leader)
+}
+
+// UpdateLeaderIfContext is like UpdateLeaderIf, but the operation is
+// abandoned if ctx is done before it completes.
+func (c *Client) UpdateLeaderIfContext(ctx context.Context, expected, leader disco.Leader) (ok bool, e error) {
+	n, now := disco.Node(leader), time.Now().UTC()
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.apply(ctx, OpUpdate, c.leaderKey, func() bool {
+			ent, ok := c.store.entries[c.leaderKey]
+			if !ok && expected != (disco.Leader{}) {
+				return false
+			}
+			if ok && disco.Leader(ent.Node) != expected {
+				return false
+			}
+			c.store.put(c.leaderKey, n, now)
+			return true
+		})
+	}, func(ctx context.Context) (bool, error) {
+		return c.isLeaderRecord(ctx, n, now)
+	})
+	e = c.do(ctx, func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		return err
+	})
+	return
+}
+
+// ResignLeader deletes the leader record, but only if it is still owned by
+// the node with the given id. ok is true if the record was deleted.
+func (c *Client) ResignLeader(id string) (bool, error) {
+	return c.ResignLeaderContext(context.Background(), id)
+}
+
+// ResignLeaderContext is like ResignLeader, but the operation is abandoned
+// if ctx is done before it completes.
+func (c *Client) ResignLeaderContext(ctx context.Context, id string) (ok bool, e error) {
+	attempt := retry.Idempotent(func(ctx context.Context) (bool, error) {
+		return c.apply(ctx, OpResign, c.leaderKey, func() bool {
+			ent, ok := c.store.entries[c.leaderKey]
+			if !ok || ent.ID != id {
+				return false
+			}
+			c.store.delete(c.leaderKey)
+			return true
+		})
+	}, c.noLeaderRecord)
+	e = c.do(ctx, func(ctx context.Context) (err error) {
+		ok, err = attempt(ctx)
+		return err
+	})
+	return
+}
+
+// DeleteLeader unconditionally deletes the leader record.
+func (c *Client) DeleteLeader() error {
+	return c.DeleteLeaderContext(context.Background())
+}
+
+// DeleteLeaderContext is like DeleteLeader, but the operation is abandoned
+// if ctx is done before it completes.
+func (c *Client) DeleteLeaderContext(ctx context.Context) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		_, err := c.apply(ctx, OpDelete, c.leaderKey, func() bool {
+			if _, ok := c.store.entries[c.leaderKey]; ok {
+				c.store.delete(c.leaderKey)
+			}
+			return true
+		})
+		return err
+	})
+}
+
+// RegisterNode records the node with the given details as a member of the
+// cluster, replacing any existing record for id.
+func (c *Client) RegisterNode(id, apiAddr, addr string) error {
+	return c.RegisterNodeContext(context.Background(), id, apiAddr, addr)
+}
+
+// RegisterNodeContext is like RegisterNode, but the operation is abandoned
+// if ctx is done before it completes.
+func (c *Client) RegisterNodeContext(ctx context.Context, id, apiAddr, addr string) error {
+	n, now := disco.Node{ID: id, APIAddr: apiAddr, Addr: addr}, time.Now().UTC()
+	return c.do(ctx, func(ctx context.Context) error {
+		_, err := c.apply(ctx, OpRegister, c.nodesKey+id, func() bool {
+			c.store.put(c.nodesKey+id, n, now)
+			return true
+		})
+		return err
+	})
+}
+
+// DeregisterNode removes the record of the node with the given id. It is
+// not an error if no such record exists.
+func (c *Client) DeregisterNode(id string) error {
+	return c.DeregisterNodeContext(context.Background(), id)
+}
+
+// DeregisterNodeContext is like DeregisterNode, but the operation is
+// abandoned if ctx is done before it completes.
+func (c *Client) DeregisterNodeContext(ctx context.Context, id string) error {
+	return c.do(ctx, func(ctx context.Context) error {
+		_, err := c.apply(ctx, OpDeregister, c.nodesKey+id, func() bool {
+			if _, ok := c.store.entries[c.nodesKey+id]; ok {
+				c.store.delete(c.nodesKey + id)
+			}
+			return true
+		})
+		return err
+	})
+}
+
+// ListNodes returns every registered node, sorted by ID.
+func (c *Client) ListNodes() ([]disco.Node, error) {
+	return c.ListNodesContext(context.Background())
+}
+
+// ListNodesContext is like ListNodes, but the operation is abandoned if ctx
+// is done before it completes.
+func (c *Client) ListNodesContext(ctx context.Context) (nodes []disco.Node, e error) {
+	e = c.do(ctx, func(ctx context.Context) error {
+		nodes = make([]disco.Node, 0)
+		_, err := c.apply(ctx, OpList, c.nodesKey, func() bool {
+			for k, e := range c.store.entries {
+				if strings.HasPrefix(k, c.nodesKey) {
+					nodes = append(nodes, e.Node)
+				}
+			}
+			return true
+		})
+		return err
+	})
+	if e != nil {
+		return nil, e
+	}
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].ID < nodes[j].ID })
+	return nodes, nil
+}
+
+// WatchLeader returns a channel on which changes to the leader record are
+// sent. If a leader is recorded when the watch starts, it is sent as the
+// first event. Changes made in quick succession may be coalesced into a
+// single event. The channel is closed once ctx is done.
+func (c *Client) WatchLeader(ctx context.Context) <-chan disco.LeaderEvent {
+	ch := make(chan disco.LeaderEvent)
+	go func() {
+		defer close(ch)
+		var lastRev uint64
+		for {
+			c.store.mu.Lock()
+			e, ok := c.store.entries[c.leaderKey]
+			changed := c.store.changed
+			c.store.mu.Unlock()
+
+			var ev *disco.LeaderEvent
+			if !ok && lastRev != 0 {
+				ev = &disco.LeaderEvent{Deleted: true}
+				lastRev = 0
+			} else if ok && e.modRev != lastRev {
+				ev = &disco.LeaderEvent{ID: e.ID, APIAddr: e.APIAddr, Addr: e.Addr}
+				lastRev = e.modRev
+			}
+			if ev != nil {
+				select {
+				case ch <- *ev:
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return ch
+}
+
+// String implements the Stringer interface.
+func (c *Client) String() string {
+	return "memkv"
+}
+
+// Close closes the client. The contents of the store are not affected.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *Client) before(ctx context.Context, op, key string) error {
+	c.mu.RLock()
+	closed := c.closed
+	c.mu.RUnlock()
+	if closed {
+		return ErrClosed
+	}
+	return c.store.before(ctx, op, key)
+}
+
+// do calls fn, retrying it according to the retry policy of the client.
+func (c *Client) do(ctx context.Context, fn func(ctx context.Context) error) error {
+	_, err := c.retryPolicy.Do(ctx, isRetryable, fn)
+	return err
+}
+
+// apply runs fn, the operation op on key, with the lock of the store held,
+// after any simulated latency and injected failure. fn returns the result of
+// the operation, which is returned unless a failure is injected afterwards.
+func (c *Client) apply(ctx context.Context, op, key string, fn func() bool) (bool, error) {
+	if err := c.before(ctx, op, key); err != nil {
+		return false, err
+	}
+	c.store.mu.Lock()
+	ok := fn()
+	c.store.mu.Unlock()
+	if err := c.store.after(op, key); err != nil {
+		return false, err
+	}
+	return ok, nil
+}
+
+// isLeaderRecord returns whether the leader record is n, as written by this
+// client at updatedAt.
+func (c *Client) isLeaderRecord(ctx context.Context, n disco.Node, updatedAt time.Time) (bool, error) {
+	rec, ok, err := c.getLeaderRecord(ctx)
+	if err != nil || !ok {
+		return false, err
+	}
+	return rec.Leader == disco.Leader(n) && rec.UpdatedAt.Equal(updatedAt), nil
+}
+
+// noLeaderRecord returns whether there is no leader record, as left by a
+// retried ResignLeaderContext whose earlier attempt deleted it.
+func (c *Client) noLeaderRecord(ctx context.Context) (bool, error) {
+	_, ok, err := c.getLeaderRecord(ctx)
+	return !ok && err == nil, err
+}
+
+// isRetryable returns whether err, returned by an operation against the
+// store, is of kind disco.ErrUnavailable.
+func isRetryable(err error) bool {
+	return errors.Is(err, disco.ErrUnavailable)
+}
+
+type entry struct {
+	disco.Node
+	createRev uint64
+	modRev    uint64
+	version   uint64
+	updatedAt time.Time
+}
@@ -0,0 +1,296 @@
+package memkv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+	"github.com/rqlite/rqlite-disco-clients/disco/disctest"
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
+func Test_NewClient(t *testing.T) {
+	c := New("rqlite", nil)
+	if c == nil {
+		t.Fatalf("returned client is nil")
+	}
+	if got, exp := c.String(), "memkv"; got != exp {
+		t.Fatalf("wrong name for client, got %s, exp %s", got, exp)
+	}
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close client: %s", err.Error())
+	}
+}
+
+func Test_LeaderStoreSuite(t *testing.T) {
+	store := NewStore()
+	disctest.RunLeaderStoreSuite(t, func(t *testing.T, key string) disco.LeaderStore {
+		return New(key, store)
+	})
+}
+
+func Test_NodeRegistrySuite(t *testing.T) {
+	store := NewStore()
+	disctest.RunNodeRegistrySuite(t, func(t *testing.T, key string) disco.LeaderStore {
+		return New(key, store)
+	})
+}
+
+func Test_LeaderStoreSuiteLatency(t *testing.T) {
+	store := NewStore()
+	store.SetLatency(time.Millisecond)
+	disctest.RunLeaderStoreSuite(t, func(t *testing.T, key string) disco.LeaderStore {
+		return New(key, store)
+	})
+}
+
+func Test_ClosedClient(t *testing.T) {
+	c := New("rqlite", nil)
+	if err := c.Close(); err != nil {
+		t.Fatalf("failed to close client: %s", err.Error())
+	}
+	if _, _, _, _, err := c.GetLeader(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from GetLeader, got %v", err)
+	}
+	if _, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from InitializeLeader, got %v", err)
+	}
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected ErrClosed from SetLeader, got %v", err)
+	}
+}
+
+func Test_Latency(t *testing.T) {
+	store := NewStore()
+	store.SetLatency(50 * time.Millisecond)
+	c := New("rqlite", store)
+	defer c.Close()
+
+	start := time.Now()
+	if _, _, _, _, err := c.GetLeader(); err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Fatalf("operation completed too quickly: %s", d)
+	}
+}
+
+func Test_InjectedFailure(t *testing.T) {
+	store := NewStore()
+	c := New("rqlite", store)
+	defer c.Close()
+
+	errInjected := errors.New("injected")
+	store.SetFailFn(func(op, key string) error {
+		if exp, got := "rqlite/leader", key; exp != got {
+			t.Fatalf("wrong key passed to fail function, exp %s, got %s", exp, got)
+		}
+		if op == OpInitialize {
+			return errInjected
+		}
+		return nil
+	})
+
+	ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if !errors.Is(err, errInjected) {
+		t.Fatalf("expected injected error, got %v", err)
+	}
+	if ok {
+		t.Fatalf("initialized leader despite injected failure")
+	}
+	_, _, _, ok, err = c.GetLeader()
+	if err != nil {
+		t.Fatalf("failed to GetLeader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("leader found after failed initialization")
+	}
+
+	store.SetFailFn(nil)
+	ok, err = c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("failed to initialize leader")
+	}
+}
+
+func Test_RetryLostResponse(t *testing.T) {
+	store := NewStore()
+	c := New("rqlite", store, WithRetry(&retry.Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}))
+	defer c.Close()
+
+	// The first attempt at each check-and-set is applied, but its response
+	// is lost.
+	var mu sync.Mutex
+	failed := make(map[string]bool)
+	store.SetFailAfterFn(func(op, key string) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if op == OpGet || failed[op] {
+			return nil
+		}
+		failed[op] = true
+		return fmt.Errorf("%w: response lost", disco.ErrUnavailable)
+	})
+
+	ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+	if err != nil {
+		t.Fatalf("error when initializing leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("initialization reported as failed although it was applied")
+	}
+	ok, err = c.UpdateLeaderIf(disco.Leader{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"},
+		disco.Leader{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"})
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("update reported as failed although it was applied")
+	}
+
+	// If another client overwrites the record before the retry, the
+	// check-and-set must be reported as failed.
+	other := New("rqlite", store)
+	defer other.Close()
+	store.SetFailAfterFn(func(op, key string) error {
+		if op != OpUpdate {
+			return nil
+		}
+		store.SetFailAfterFn(nil)
+		if err := other.SetLeader("3", "http://localhost:4005", "localhost:4006"); err != nil {
+			t.Errorf("error when setting leader: %s", err.Error())
+		}
+		return fmt.Errorf("%w: response lost", disco.ErrUnavailable)
+	})
+	ok, err = c.UpdateLeaderIf(disco.Leader{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"},
+		disco.Leader{ID: "4", APIAddr: "http://localhost:4007", Addr: "localhost:4008"})
+	if err != nil {
+		t.Fatalf("error when updating leader: %s", err.Error())
+	}
+	if ok {
+		t.Fatalf("update reported as applied although the record was overwritten")
+	}
+	if id, _, _, _, err := c.GetLeader(); err != nil || id != "3" {
+		t.Fatalf("wrong leader, exp 3, got %s (%v)", id, err)
+	}
+	// A resignation whose delete is applied, but whose response is lost,
+	// must be reported as applied.
+	store.SetFailAfterFn(func(op, key string) error {
+		if op != OpResign {
+			return nil
+		}
+		store.SetFailAfterFn(nil)
+		return fmt.Errorf("%w: response lost", disco.ErrUnavailable)
+	})
+	ok, err = c.ResignLeader("3")
+	if err != nil {
+		t.Fatalf("error when resigning leader: %s", err.Error())
+	}
+	if !ok {
+		t.Fatalf("resignation reported as failed although it was applied")
+	}
+	if id, _, _, _, err := c.GetLeader(); err != nil || id != "" {
+		t.Fatalf("leader not deleted, got %s (%v)", id, err)
+	}
+}
+
+func Test_NewFromConfig(t *testing.T) {
+	c1, err := disco.NewLeaderStoreFromConfig("memkv", nil, disco.WithKey("cluster1"))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c1.Close()
+	if exp, got := "memkv", c1.String(); exp != got {
+		t.Fatalf("wrong mode, exp %s, got %s", exp, got)
+	}
+	c2, err := disco.NewLeaderStoreFromConfig("memkv", nil, disco.WithKey("cluster1"))
+	if err != nil {
+		t.Fatalf("failed to create new client: %s", err.Error())
+	}
+	defer c2.Close()
+
+	// Clients built for the same key must share their records.
+	if err := c1.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	if id, _, _, ok, err := c2.GetLeader(); err != nil || !ok || id != "1" {
+		t.Fatalf("wrong leader, exp 1, got %s (%t, %v)", id, ok, err)
+	}
+
+	if _, err := disco.NewLookuperFromConfig("memkv", nil); !errors.Is(err, disco.ErrInvalidConfig) {
+		t.Fatalf("expected ErrInvalidConfig, got %v", err)
+	}
+}
+
+func Test_SharedStore(t *testing.T) {
+	store := NewStore()
+	c1 := New("rqlite", store)
+	defer c1.Close()
+	c2 := New("rqlite", store)
+	defer c2.Close()
+
+	var wg sync.WaitGroup
+	results := make([]bool, 2)
+	for i, c := range []*Client{c1, c2} {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			ok, err := c.InitializeLeader("1", "http://localhost:4001", "localhost:4002")
+			if err != nil {
+				t.Errorf("error when initializing leader: %s", err.Error())
+			}
+			results[i] = ok
+		}(i, c)
+	}
+	wg.Wait()
+	if results[0] == results[1] {
+		t.Fatalf("expected exactly one initializer to succeed, got %v", results)
+	}
+}
+
+func Test_WatchLeader(t *testing.T) {
+	store := NewStore()
+	c := New("rqlite", store)
+	defer c.Close()
+
+	if err := c.SetLeader("1", "http://localhost:4001", "localhost:4002"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ch := c.WatchLeader(ctx)
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "1", APIAddr: "http://localhost:4001", Addr: "localhost:4002"})
+
+	// Changes to other keys must not generate events.
+	if err := New("other", store).SetLeader("3", "http://localhost:4005", "localhost:4006"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	if err := c.SetLeader("2", "http://localhost:4003", "localhost:4004"); err != nil {
+		t.Fatalf("error when setting leader: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{ID: "2", APIAddr: "http://localhost:4003", Addr: "localhost:4004"})
+
+	if err := c.DeleteLeader(); err != nil {
+		t.Fatalf("error when deleting leader: %s", err.Error())
+	}
+	testutil.MustReceiveLeaderEvent(t, ch, disco.LeaderEvent{Deleted: true})
+
+	cancel()
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("watch channel not closed")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for watch channel to close")
+	}
+}
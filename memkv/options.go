@@ -0,0 +1,21 @@
+package memkv
+
+import (
+	"github.com/rqlite/rqlite-disco-clients/retry"
+)
+
+// Option configures a Client created by New.
+type Option func(*options)
+
+type options struct {
+	retry *retry.Policy
+}
+
+// WithRetry sets the policy for retrying operations which fail with errors
+// of kind disco.ErrUnavailable, such as those injected by the functions set
+// with SetFailFn and SetFailAfterFn. A nil policy disables retries.
+func WithRetry(p *retry.Policy) Option {
+	return func(o *options) {
+		o.retry = p
+	}
+}
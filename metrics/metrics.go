@@ -0,0 +1,296 @@
+// Package metrics collects metrics about the operations of the clients in
+// this module: the number of operations and lookups, their errors by kind,
+// the results of check-and-set operations, and latency histograms.
+//
+// Metrics are exposed through expvar, by publishing a Registry, and in the
+// Prometheus text exposition format by the http.Handler returned by
+// Registry.Handler, without depending on the Prometheus client library.
+// Clients record to Default unless configured with another Registry.
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+// Buckets are the upper bounds, in seconds, of the buckets of the latency
+// histograms.
+var Buckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Default is the Registry to which clients record unless configured
+// otherwise.
+var Default = NewRegistry()
+
+// Values of the kind label of errors, by the sentinel error they wrap.
+var errorKinds = []struct {
+	err  error
+	kind string
+}{
+	{disco.ErrNotFound, "not_found"},
+	{disco.ErrUnavailable, "unavailable"},
+	{disco.ErrPermissionDenied, "permission_denied"},
+	{disco.ErrConflict, "conflict"},
+	{disco.ErrCorruptRecord, "corrupt_record"},
+	{disco.ErrNoAddresses, "no_addresses"},
+	{context.Canceled, "canceled"},
+	{context.DeadlineExceeded, "deadline_exceeded"},
+}
+
+// ErrorKind returns the value of the kind label for err.
+func ErrorKind(err error) string {
+	for _, k := range errorKinds {
+		if errors.Is(err, k.err) {
+			return k.kind
+		}
+	}
+	return "other"
+}
+
+// key identifies the metrics of one operation of one backend.
+type key struct {
+	backend string
+	op      string
+}
+
+// op holds the metrics of one operation of one backend.
+type op struct {
+	count   uint64
+	errors  map[string]uint64
+	cas     map[string]uint64
+	buckets []uint64 // Non-cumulative counts, by index in Buckets.
+	sum     float64  // Total latency in seconds.
+}
+
+// Registry holds the metrics recorded by clients. A nil *Registry discards
+// everything recorded to it. A Registry is safe for concurrent use, and
+// implements expvar.Var.
+type Registry struct {
+	mu  sync.Mutex
+	ops map[key]*op
+}
+
+var _ expvar.Var = (*Registry)(nil)
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{ops: make(map[key]*op)}
+}
+
+// op returns the metrics of op of backend, creating them if needed. r.mu
+// must be held.
+func (r *Registry) op(backend, name string) *op {
+	k := key{backend: backend, op: name}
+	o, ok := r.ops[k]
+	if !ok {
+		o = &op{
+			errors:  make(map[string]uint64),
+			cas:     make(map[string]uint64),
+			buckets: make([]uint64, len(Buckets)),
+		}
+		r.ops[k] = o
+	}
+	return o
+}
+
+// ObserveOp records that operation op of backend took d, and failed with
+// err if it is not nil.
+func (r *Registry) ObserveOp(backend, op string, d time.Duration, err error) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	o := r.op(backend, op)
+	o.count++
+	if err != nil {
+		o.errors[ErrorKind(err)]++
+	}
+	s := d.Seconds()
+	o.sum += s
+	if i := sort.SearchFloat64s(Buckets, s); i < len(Buckets) {
+		o.buckets[i]++
+	}
+}
+
+// ObserveCAS records the result of check-and-set operation op of backend,
+// which succeeded if ok is true, and was rejected because of a conflicting
+// write otherwise.
+func (r *Registry) ObserveCAS(backend, op string, ok bool) {
+	if r == nil {
+		return
+	}
+	result := "conflict"
+	if ok {
+		result = "ok"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.op(backend, op).cas[result]++
+}
+
+// sortedKeys returns the keys of r.ops, sorted by backend and operation.
+// r.mu must be held.
+func (r *Registry) sortedKeys() []key {
+	keys := make([]key, 0, len(r.ops))
+	for k := range r.ops {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].backend != keys[j].backend {
+			return keys[i].backend < keys[j].backend
+		}
+		return keys[i].op < keys[j].op
+	})
+	return keys
+}
+
+// String returns the metrics as a JSON object, keyed by backend and then
+// operation, implementing expvar.Var.
+func (r *Registry) String() string {
+	if r == nil {
+		return "{}"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	backends := make(map[string]map[string]interface{})
+	for k, o := range r.ops {
+		if backends[k.backend] == nil {
+			backends[k.backend] = make(map[string]interface{})
+		}
+		buckets := make(map[string]uint64, len(Buckets)+1)
+		var n uint64
+		for i, b := range Buckets {
+			n += o.buckets[i]
+			buckets[formatFloat(b)] = n
+		}
+		buckets["+Inf"] = o.count
+		m := map[string]interface{}{
+			"count":               o.count,
+			"latency_seconds_sum": o.sum,
+			"latency_seconds_le":  buckets,
+			"errors":              o.errors,
+		}
+		if len(o.cas) > 0 {
+			m["cas"] = o.cas
+		}
+		backends[k.backend][k.op] = m
+	}
+	b, err := json.Marshal(backends)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// Publish publishes r through expvar under name. Like expvar.Publish, it
+// panics if name is already in use.
+func (r *Registry) Publish(name string) {
+	expvar.Publish(name, r)
+}
+
+// WritePrometheus writes the metrics to w in the Prometheus text exposition
+// format.
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if r != nil {
+		r.mu.Lock()
+		r.writePrometheus(bw)
+		r.mu.Unlock()
+	}
+	return bw.Flush()
+}
+
+// writePrometheus writes the metrics to w. r.mu must be held.
+func (r *Registry) writePrometheus(w *bufio.Writer) {
+	keys := r.sortedKeys()
+
+	writeHeader(w, "disco_operations_total", "counter",
+		"Number of operations, including lookups, performed by discovery clients.")
+	for _, k := range keys {
+		fmt.Fprintf(w, "disco_operations_total{%s} %d\n", labels(k), r.ops[k].count)
+	}
+
+	writeHeader(w, "disco_errors_total", "counter",
+		"Number of failed operations of discovery clients, by kind of error.")
+	for _, k := range keys {
+		o := r.ops[k]
+		for _, kind := range sortedMapKeys(o.errors) {
+			fmt.Fprintf(w, "disco_errors_total{%s,kind=%s} %d\n", labels(k), quote(kind), o.errors[kind])
+		}
+	}
+
+	writeHeader(w, "disco_cas_total", "counter",
+		"Number of check-and-set operations of discovery clients, by result.")
+	for _, k := range keys {
+		o := r.ops[k]
+		for _, result := range sortedMapKeys(o.cas) {
+			fmt.Fprintf(w, "disco_cas_total{%s,result=%s} %d\n", labels(k), quote(result), o.cas[result])
+		}
+	}
+
+	writeHeader(w, "disco_operation_duration_seconds", "histogram",
+		"Latency of the operations of discovery clients, including retries.")
+	for _, k := range keys {
+		o := r.ops[k]
+		l := labels(k)
+		var n uint64
+		for i, b := range Buckets {
+			n += o.buckets[i]
+			fmt.Fprintf(w, "disco_operation_duration_seconds_bucket{%s,le=%s} %d\n", l, quote(formatFloat(b)), n)
+		}
+		fmt.Fprintf(w, "disco_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", l, o.count)
+		fmt.Fprintf(w, "disco_operation_duration_seconds_sum{%s} %s\n", l, formatFloat(o.sum))
+		fmt.Fprintf(w, "disco_operation_duration_seconds_count{%s} %d\n", l, o.count)
+	}
+}
+
+// Handler returns an http.Handler serving the metrics in the Prometheus
+// text exposition format.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WritePrometheus(w)
+	})
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func labels(k key) string {
+	return "backend=" + quote(k.backend) + ",op=" + quote(k.op)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// quote returns s as a quoted label value.
+func quote(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func sortedMapKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
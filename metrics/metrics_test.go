@@ -0,0 +1,109 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+func Test_ErrorKind(t *testing.T) {
+	testCases := []struct {
+		err error
+		exp string
+	}{
+		{disco.WrapError("dns", "lookup", disco.ErrNotFound, io.EOF), "not_found"},
+		{fmt.Errorf("%w for rqlite", disco.ErrNoAddresses), "no_addresses"},
+		{disco.ErrCorruptRecord, "corrupt_record"},
+		{context.DeadlineExceeded, "deadline_exceeded"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tc := range testCases {
+		if got := ErrorKind(tc.err); got != tc.exp {
+			t.Fatalf("wrong kind for %v, exp %s, got %s", tc.err, tc.exp, got)
+		}
+	}
+}
+
+func Test_RegistryPrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveOp("dns", "lookup", 3*time.Millisecond, nil)
+	r.ObserveOp("dns", "lookup", 2*time.Second, disco.ErrUnavailable)
+	r.ObserveOp("consul-kv", "initialize_leader", 20*time.Millisecond, nil)
+	r.ObserveCAS("consul-kv", "initialize_leader", true)
+	r.ObserveCAS("consul-kv", "initialize_leader", false)
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if exp, got := "text/plain; version=0.0.4; charset=utf-8", rec.Header().Get("Content-Type"); exp != got {
+		t.Fatalf("wrong content type, exp %s, got %s", exp, got)
+	}
+	body := rec.Body.String()
+	for _, exp := range []string{
+		"# TYPE disco_operations_total counter\n",
+		`disco_operations_total{backend="consul-kv",op="initialize_leader"} 1` + "\n",
+		`disco_operations_total{backend="dns",op="lookup"} 2` + "\n",
+		`disco_errors_total{backend="dns",op="lookup",kind="unavailable"} 1` + "\n",
+		`disco_cas_total{backend="consul-kv",op="initialize_leader",result="conflict"} 1` + "\n",
+		`disco_cas_total{backend="consul-kv",op="initialize_leader",result="ok"} 1` + "\n",
+		"# TYPE disco_operation_duration_seconds histogram\n",
+		`disco_operation_duration_seconds_bucket{backend="dns",op="lookup",le="0.005"} 1` + "\n",
+		`disco_operation_duration_seconds_bucket{backend="dns",op="lookup",le="1"} 1` + "\n",
+		`disco_operation_duration_seconds_bucket{backend="dns",op="lookup",le="2.5"} 2` + "\n",
+		`disco_operation_duration_seconds_bucket{backend="dns",op="lookup",le="+Inf"} 2` + "\n",
+		`disco_operation_duration_seconds_sum{backend="dns",op="lookup"} 2.003` + "\n",
+		`disco_operation_duration_seconds_count{backend="dns",op="lookup"} 2` + "\n",
+	} {
+		if !strings.Contains(body, exp) {
+			t.Fatalf("output does not contain %q:\n%s", exp, body)
+		}
+	}
+	if strings.Index(body, `backend="consul-kv"`) > strings.Index(body, `backend="dns"`) {
+		t.Fatalf("output not sorted by backend:\n%s", body)
+	}
+}
+
+func Test_RegistryExpvar(t *testing.T) {
+	r := NewRegistry()
+	r.ObserveOp("etcd-kv", "get_leader", time.Millisecond, nil)
+	r.ObserveOp("etcd-kv", "get_leader", time.Millisecond, disco.ErrPermissionDenied)
+
+	var m map[string]map[string]struct {
+		Count  uint64            `json:"count"`
+		Errors map[string]uint64 `json:"errors"`
+		LE     map[string]uint64 `json:"latency_seconds_le"`
+	}
+	if err := json.Unmarshal([]byte(r.String()), &m); err != nil {
+		t.Fatalf("failed to unmarshal expvar output: %s", err.Error())
+	}
+	op := m["etcd-kv"]["get_leader"]
+	if op.Count != 2 {
+		t.Fatalf("wrong count, exp 2, got %d", op.Count)
+	}
+	if op.Errors["permission_denied"] != 1 {
+		t.Fatalf("wrong errors: %v", op.Errors)
+	}
+	if op.LE["0.005"] != 2 || op.LE["+Inf"] != 2 {
+		t.Fatalf("wrong buckets: %v", op.LE)
+	}
+}
+
+func Test_RegistryNil(t *testing.T) {
+	var r *Registry
+	r.ObserveOp("dns", "lookup", time.Millisecond, nil)
+	r.ObserveCAS("consul-kv", "set_leader", true)
+	if exp, got := "{}", r.String(); exp != got {
+		t.Fatalf("wrong expvar output, exp %s, got %s", exp, got)
+	}
+	var b strings.Builder
+	if err := r.WritePrometheus(&b); err != nil || b.Len() != 0 {
+		t.Fatalf("unexpected output for nil registry: %q %v", b.String(), err)
+	}
+}
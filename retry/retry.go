@@ -0,0 +1,192 @@
+// Package retry retries operations which fail with transient errors, using
+// exponential backoff with jitter. A Policy is set through the configuration
+// of each client, under the "retry" key.
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"syscall"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/disco"
+)
+
+const (
+	// DefaultMaxAttempts is the number of attempts made if MaxAttempts is
+	// not set.
+	DefaultMaxAttempts = 3
+
+	// DefaultInitialBackoff is the backoff before the first retry if
+	// InitialBackoff is not set.
+	DefaultInitialBackoff = 100 * time.Millisecond
+
+	// DefaultMaxBackoff is the maximum backoff between attempts if
+	// MaxBackoff is not set.
+	DefaultMaxBackoff = 5 * time.Second
+
+	// DefaultMultiplier is the factor by which the backoff grows after
+	// each attempt if Multiplier is not set.
+	DefaultMultiplier = 2.0
+)
+
+// Policy configures how failed operations are retried. The time-related
+// values are in units of nanoseconds. A nil Policy makes a single attempt.
+type Policy struct {
+	// MaxAttempts is the maximum number of attempts, including the first.
+	MaxAttempts int `json:"max_attempts,omitempty"`
+
+	// InitialBackoff is the backoff before the first retry.
+	InitialBackoff time.Duration `json:"initial_backoff,omitempty"`
+
+	// MaxBackoff is the maximum backoff between attempts.
+	MaxBackoff time.Duration `json:"max_backoff,omitempty"`
+
+	// Multiplier is the factor by which the backoff grows after each
+	// attempt.
+	Multiplier float64 `json:"multiplier,omitempty"`
+
+	// Deadline, if set, bounds the total time taken by all attempts,
+	// including the backoff between them.
+	Deadline time.Duration `json:"deadline,omitempty"`
+}
+
+// Validate checks the values of p, returning a *disco.ConfigError listing
+// every invalid field. A nil Policy is valid.
+func (p *Policy) Validate() error {
+	if p == nil {
+		return nil
+	}
+	var v disco.Validator
+	if p.MaxAttempts < 0 {
+		v.Add("max_attempts", "must not be negative")
+	}
+	if p.InitialBackoff < 0 {
+		v.Add("initial_backoff", "must not be negative")
+	}
+	if p.MaxBackoff < 0 {
+		v.Add("max_backoff", "must not be negative")
+	} else if p.InitialBackoff > 0 && p.MaxBackoff > 0 && p.MaxBackoff < p.InitialBackoff {
+		v.Add("max_backoff", "must not be less than initial_backoff")
+	}
+	if p.Multiplier < 0 {
+		v.Add("multiplier", "must not be negative")
+	} else if p.Multiplier > 0 && p.Multiplier < 1 {
+		v.Add("multiplier", "must be at least 1")
+	}
+	if p.Deadline < 0 {
+		v.Add("deadline", "must not be negative")
+	}
+	return v.Err()
+}
+
+// Do calls fn until it succeeds, returns an error for which retryable
+// returns false, the attempts are exhausted, or the deadline of the policy
+// or ctx passes. The error of the last attempt is returned, along with the
+// number of retries made. The backoff before each retry is chosen at random
+// between half and all of the current backoff, so that clients which fail
+// together do not retry together.
+func (p *Policy) Do(ctx context.Context, retryable func(error) bool, fn func(ctx context.Context) error) (int, error) {
+	if p == nil {
+		return 0, fn(ctx)
+	}
+	if p.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Deadline)
+		defer cancel()
+	}
+
+	backoff := p.initialBackoff()
+	for retries := 0; ; retries++ {
+		err := fn(ctx)
+		if err == nil || !retryable(err) || retries+1 >= p.maxAttempts() {
+			return retries, err
+		}
+
+		d := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+			// There is no time left for another attempt.
+			return retries, err
+		}
+		t := time.NewTimer(d)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return retries, err
+		}
+		backoff = min(time.Duration(float64(backoff)*p.multiplier()), p.maxBackoff())
+	}
+}
+
+// Idempotent returns fn, an attempt at a check-and-set which reports whether
+// its check passed, made safe to retry. An attempt which fails may have been
+// applied nevertheless, its response having been lost, in which case the
+// check of the next attempt fails although the write is in place. When an
+// attempt which follows a failed one reports that its check failed, applied
+// is called to tell whether the record is still the one written by the
+// attempts, or is still absent if they delete it, and its result is returned
+// instead. Each attempt must write the same record, and the function
+// returned must not be shared between operations.
+func Idempotent(fn, applied func(ctx context.Context) (bool, error)) func(ctx context.Context) (bool, error) {
+	failed := false
+	return func(ctx context.Context) (bool, error) {
+		ok, err := fn(ctx)
+		if err == nil && !ok && failed {
+			ok, err = applied(ctx)
+		}
+		failed = err != nil
+		return ok, err
+	}
+}
+
+func (p *Policy) maxAttempts() int {
+	if p.MaxAttempts > 0 {
+		return p.MaxAttempts
+	}
+	return DefaultMaxAttempts
+}
+
+func (p *Policy) initialBackoff() time.Duration {
+	if p.InitialBackoff > 0 {
+		return p.InitialBackoff
+	}
+	return DefaultInitialBackoff
+}
+
+func (p *Policy) maxBackoff() time.Duration {
+	if p.MaxBackoff > 0 {
+		return p.MaxBackoff
+	}
+	return DefaultMaxBackoff
+}
+
+func (p *Policy) multiplier() float64 {
+	if p.Multiplier > 0 {
+		return p.Multiplier
+	}
+	return DefaultMultiplier
+}
+
+// Transient returns whether err is a network error which is likely to be
+// transient, such as a timeout, a refused or reset connection, or a
+// temporary DNS failure. Errors caused by the cancellation or deadline of a
+// context are not transient.
+func Transient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsTemporary {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
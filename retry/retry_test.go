@@ -0,0 +1,256 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/rqlite/rqlite-disco-clients/internal/testutil"
+)
+
+var errTransient = errors.New("transient")
+
+func isTransient(err error) bool {
+	return errors.Is(err, errTransient)
+}
+
+func Test_NilPolicy(t *testing.T) {
+	var p *Policy
+	calls := 0
+	retries, err := p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("wrong error, exp %v, got %v", errTransient, err)
+	}
+	if calls != 1 || retries != 0 {
+		t.Fatalf("wrong number of attempts, exp 1 call and 0 retries, got %d and %d", calls, retries)
+	}
+}
+
+func Test_RetryUntilSuccess(t *testing.T) {
+	p := &Policy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+	calls := 0
+	retries, err := p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		if calls < 3 {
+			return errTransient
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	if calls != 3 || retries != 2 {
+		t.Fatalf("wrong number of attempts, exp 3 calls and 2 retries, got %d and %d", calls, retries)
+	}
+}
+
+func Test_MaxAttempts(t *testing.T) {
+	p := &Policy{MaxAttempts: 4, InitialBackoff: time.Millisecond}
+	calls := 0
+	retries, err := p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("wrong error, exp %v, got %v", errTransient, err)
+	}
+	if calls != 4 || retries != 3 {
+		t.Fatalf("wrong number of attempts, exp 4 calls and 3 retries, got %d and %d", calls, retries)
+	}
+}
+
+func Test_DefaultMaxAttempts(t *testing.T) {
+	p := &Policy{InitialBackoff: time.Millisecond}
+	calls := 0
+	p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		return errTransient
+	})
+	if calls != DefaultMaxAttempts {
+		t.Fatalf("wrong number of calls, exp %d, got %d", DefaultMaxAttempts, calls)
+	}
+}
+
+func Test_NotRetryable(t *testing.T) {
+	p := &Policy{MaxAttempts: 5, InitialBackoff: time.Millisecond}
+	permanent := errors.New("permanent")
+	calls := 0
+	retries, err := p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		return permanent
+	})
+	if !errors.Is(err, permanent) {
+		t.Fatalf("wrong error, exp %v, got %v", permanent, err)
+	}
+	if calls != 1 || retries != 0 {
+		t.Fatalf("wrong number of attempts, exp 1 call and 0 retries, got %d and %d", calls, retries)
+	}
+}
+
+func Test_Deadline(t *testing.T) {
+	p := &Policy{MaxAttempts: 1000, InitialBackoff: 50 * time.Millisecond, MaxBackoff: 50 * time.Millisecond, Deadline: 300 * time.Millisecond}
+	start := time.Now()
+	calls := 0
+	_, err := p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Fatalf("attempt not bound to deadline")
+		}
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("wrong error, exp %v, got %v", errTransient, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("deadline not honored, took %s", d)
+	}
+	if calls < 2 || calls > 13 {
+		t.Fatalf("unexpected number of calls within deadline: %d", calls)
+	}
+}
+
+func Test_ContextCanceled(t *testing.T) {
+	p := &Policy{MaxAttempts: 5, InitialBackoff: time.Hour}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	_, err := p.Do(ctx, isTransient, func(ctx context.Context) error {
+		calls++
+		return errTransient
+	})
+	if !errors.Is(err, errTransient) {
+		t.Fatalf("wrong error, exp %v, got %v", errTransient, err)
+	}
+	if calls != 1 {
+		t.Fatalf("wrong number of calls, exp 1, got %d", calls)
+	}
+}
+
+func Test_Backoff(t *testing.T) {
+	p := &Policy{MaxAttempts: 4, InitialBackoff: 20 * time.Millisecond, Multiplier: 2, MaxBackoff: 40 * time.Millisecond}
+	var times []time.Time
+	p.Do(context.Background(), isTransient, func(ctx context.Context) error {
+		times = append(times, time.Now())
+		return errTransient
+	})
+	// Backoffs are 20ms, 40ms and 40ms, each reduced by up to half.
+	mins := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 20 * time.Millisecond}
+	for i := range mins {
+		if d := times[i+1].Sub(times[i]); d < mins[i] {
+			t.Fatalf("backoff %d too short, exp at least %s, got %s", i, mins[i], d)
+		}
+	}
+}
+
+func Test_Idempotent(t *testing.T) {
+	p := &Policy{MaxAttempts: 3, InitialBackoff: time.Millisecond}
+	for _, tt := range []struct {
+		name    string
+		applied bool
+		exp     bool
+	}{
+		{"applied", true, true},
+		{"not applied", false, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, checks := 0, 0
+			attempt := Idempotent(func(ctx context.Context) (bool, error) {
+				calls++
+				if calls == 1 {
+					// The write is applied, but its response is lost.
+					return false, errTransient
+				}
+				return false, nil
+			}, func(ctx context.Context) (bool, error) {
+				checks++
+				return tt.applied, nil
+			})
+			var ok bool
+			_, err := p.Do(context.Background(), isTransient, func(ctx context.Context) (err error) {
+				ok, err = attempt(ctx)
+				return err
+			})
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err.Error())
+			}
+			if ok != tt.exp {
+				t.Fatalf("wrong result, exp %t, got %t", tt.exp, ok)
+			}
+			if calls != 2 || checks != 1 {
+				t.Fatalf("wrong number of calls, exp 2 attempts and 1 check, got %d and %d", calls, checks)
+			}
+		})
+	}
+
+	// A failed check is reported as such if no attempt failed before.
+	checks := 0
+	attempt := Idempotent(func(ctx context.Context) (bool, error) {
+		return false, nil
+	}, func(ctx context.Context) (bool, error) {
+		checks++
+		return true, nil
+	})
+	if ok, err := attempt(context.Background()); ok || err != nil || checks != 0 {
+		t.Fatalf("wrong result of first attempt, got %t, %v and %d checks", ok, err, checks)
+	}
+}
+
+func Test_Transient(t *testing.T) {
+	testCases := []struct {
+		err error
+		exp bool
+	}{
+		{errors.New("boom"), false},
+		{context.Canceled, false},
+		{context.DeadlineExceeded, false},
+		{fmt.Errorf("dial: %w", syscall.ECONNREFUSED), true},
+		{&net.OpError{Op: "read", Err: syscall.ECONNRESET}, true},
+		{&net.DNSError{Err: "timeout", IsTimeout: true}, true},
+		{&net.DNSError{Err: "no such host", IsNotFound: true}, false},
+		{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, true},
+	}
+	for _, tc := range testCases {
+		if got := Transient(tc.err); got != tc.exp {
+			t.Fatalf("wrong result for %v, exp %v, got %v", tc.err, tc.exp, got)
+		}
+	}
+}
+
+func Test_PolicyValidate(t *testing.T) {
+	var p *Policy
+	if err := p.Validate(); err != nil {
+		t.Fatalf("nil policy is invalid: %s", err)
+	}
+	p = &Policy{MaxAttempts: 3, InitialBackoff: time.Second, MaxBackoff: time.Second, Multiplier: 1}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("valid policy is invalid: %s", err)
+	}
+
+	p = &Policy{
+		MaxAttempts:    -1,
+		InitialBackoff: time.Second,
+		MaxBackoff:     time.Millisecond,
+		Multiplier:     0.5,
+		Deadline:       -1,
+	}
+	exp := []string{"max_attempts", "max_backoff", "multiplier", "deadline"}
+	if got := testutil.InvalidFields(t, p.Validate()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+	p = &Policy{InitialBackoff: -1, MaxBackoff: -1, Multiplier: -1}
+	exp = []string{"initial_backoff", "max_backoff", "multiplier"}
+	if got := testutil.InvalidFields(t, p.Validate()); !reflect.DeepEqual(exp, got) {
+		t.Fatalf("wrong invalid fields, exp %v, got %v", exp, got)
+	}
+}